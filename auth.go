@@ -0,0 +1,202 @@
+package libp2pgrpc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorInfo reasons attached to the errors of denied RPCs.
+const (
+	ReasonPeerNotAllowed = "PEER_NOT_ALLOWED"
+	ReasonPeerUnknown    = "PEER_UNKNOWN"
+)
+
+// Policy decides which peers may call which methods.
+type Policy interface {
+	// Allow reports whether the peer described by info may call
+	// fullMethod, e.g. "/proto.v1.NodeService/Info".
+	Allow(ctx context.Context, info *AuthInfo, fullMethod string) bool
+}
+
+// PolicyFunc adapts a function to a Policy.
+type PolicyFunc func(ctx context.Context, info *AuthInfo, fullMethod string) bool
+
+func (f PolicyFunc) Allow(ctx context.Context, info *AuthInfo, fullMethod string) bool {
+	return f(ctx, info, fullMethod)
+}
+
+// PeerPolicy is a Policy that decides on the peer alone, whatever the
+// method. Such policies can also gate connections, see
+// NewConnectionGater.
+type PeerPolicy interface {
+	Policy
+
+	// AllowPeer reports whether the peer may call any method.
+	AllowPeer(id peer.ID) bool
+}
+
+var _ PeerPolicy = &Allowlist{}
+
+// Allowlist is a PeerPolicy allowing a set of peers. It is safe to
+// update while in use.
+type Allowlist struct {
+	mu  sync.RWMutex
+	ids map[peer.ID]struct{}
+}
+
+// AllowPeers returns an Allowlist of the given peers.
+func AllowPeers(ids ...peer.ID) *Allowlist {
+	a := &Allowlist{ids: make(map[peer.ID]struct{}, len(ids))}
+	a.Add(ids...)
+	return a
+}
+
+// AllowPublicKeys returns an Allowlist of the peers owning the given
+// public keys. Keys that don't map to a peer ID are left out.
+func AllowPublicKeys(keys ...crypto.PubKey) *Allowlist {
+	a := AllowPeers()
+	for _, key := range keys {
+		if id, err := peer.IDFromPublicKey(key); err == nil {
+			a.Add(id)
+		}
+	}
+	return a
+}
+
+// Add allows the given peers.
+func (a *Allowlist) Add(ids ...peer.ID) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, id := range ids {
+		a.ids[id] = struct{}{}
+	}
+}
+
+// Remove stops allowing the given peers.
+func (a *Allowlist) Remove(ids ...peer.ID) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, id := range ids {
+		delete(a.ids, id)
+	}
+}
+
+func (a *Allowlist) AllowPeer(id peer.ID) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	_, ok := a.ids[id]
+	return ok
+}
+
+func (a *Allowlist) Allow(_ context.Context, info *AuthInfo, _ string) bool {
+	return a.AllowPeer(info.ID)
+}
+
+// WithAuthorization makes the Server check every RPC against p, see
+// UnaryAuthorizationInterceptor.
+func WithAuthorization(p Policy) ServerOption {
+	return ServerOption{apply: func(s *Server) {
+		s.intercept(UnaryAuthorizationInterceptor(p), StreamAuthorizationInterceptor(p))
+	}}
+}
+
+// UnaryAuthorizationInterceptor denies the unary RPCs p doesn't allow
+// with codes.PermissionDenied, and the RPCs not coming from a libp2p
+// peer with codes.Unauthenticated. The errors carry an
+// errdetails.ErrorInfo naming the peer and method.
+func UnaryAuthorizationInterceptor(p Policy) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if err := authorize(ctx, p, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamAuthorizationInterceptor is the streaming counterpart of
+// UnaryAuthorizationInterceptor.
+func StreamAuthorizationInterceptor(p Policy) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := authorize(ss.Context(), p, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func authorize(ctx context.Context, p Policy, fullMethod string) error {
+	info, ok := PeerFromContext(ctx)
+	if !ok {
+		return authError(codes.Unauthenticated, ReasonPeerUnknown, "", fullMethod,
+			"libp2pgrpc: "+fullMethod+" called without a libp2p peer")
+	}
+	if !p.Allow(ctx, info, fullMethod) {
+		return authError(codes.PermissionDenied, ReasonPeerNotAllowed, info.ID.String(), fullMethod,
+			"libp2pgrpc: peer "+info.ID.String()+" may not call "+fullMethod)
+	}
+	return nil
+}
+
+func authError(code codes.Code, reason, peerID, fullMethod, msg string) error {
+	md := map[string]string{"method": fullMethod}
+	if peerID != "" {
+		md["peer_id"] = peerID
+	}
+
+	st, err := status.New(code, msg).WithDetails(&errdetails.ErrorInfo{
+		Reason:   reason,
+		Domain:   AuthType,
+		Metadata: md,
+	})
+	if err != nil {
+		return status.Error(code, msg)
+	}
+	return st.Err()
+}
+
+// NewConnectionGater returns a libp2p ConnectionGater refusing the
+// connections of the peers p doesn't allow, so they can't open the gRPC
+// protocol stream in the first place. Pass it to libp2p.ConnectionGater.
+// It gates the whole connection, not just gRPC, so it suits hosts
+// dedicated to serving gRPC.
+func NewConnectionGater(p PeerPolicy) connmgr.ConnectionGater {
+	return &policyGater{policy: p}
+}
+
+type policyGater struct {
+	policy PeerPolicy
+}
+
+func (g *policyGater) InterceptPeerDial(id peer.ID) bool {
+	return g.policy.AllowPeer(id)
+}
+
+func (g *policyGater) InterceptAddrDial(peer.ID, multiaddr.Multiaddr) bool {
+	return true
+}
+
+func (g *policyGater) InterceptAccept(network.ConnMultiaddrs) bool {
+	return true
+}
+
+func (g *policyGater) InterceptSecured(_ network.Direction, id peer.ID, _ network.ConnMultiaddrs) bool {
+	return g.policy.AllowPeer(id)
+}
+
+func (g *policyGater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
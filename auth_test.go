@@ -0,0 +1,131 @@
+package libp2pgrpc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/status"
+
+	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
+	proto "github.com/drgomesp/go-libp2p-grpc/proto/v1"
+)
+
+func serveAuthorized(t *testing.T, srvHost host.Host, p libp2pgrpc.Policy) {
+	srv, err := libp2pgrpc.NewGrpcServer(context.Background(), srvHost, libp2pgrpc.WithAuthorization(p))
+	assert.NoError(t, err)
+	t.Cleanup(srv.Stop)
+
+	proto.RegisterNodeServiceServer(srv, &NodeInfoService{host: srvHost})
+	testpb.RegisterTestServiceServer(srv, grpcWebTestService{})
+	go srv.Serve()
+}
+
+func dialNode(t *testing.T, cliHost, srvHost host.Host) *grpc.ClientConn {
+	conn, err := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID).Dial(context.Background(), srvHost.ID())
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func callNode(conn *grpc.ClientConn) error {
+	_, err := proto.NewNodeServiceClient(conn).Info(context.Background(), &proto.NodeInfoRequest{}, grpc.WaitForReady(true))
+	return err
+}
+
+func assertDenied(t *testing.T, err error, id peer.ID, method string) {
+	st := status.Convert(err)
+	assert.Equal(t, codes.PermissionDenied, st.Code())
+
+	if assert.Len(t, st.Details(), 1) {
+		info, ok := st.Details()[0].(*errdetails.ErrorInfo)
+		if assert.True(t, ok) {
+			assert.Equal(t, libp2pgrpc.ReasonPeerNotAllowed, info.GetReason())
+			assert.Equal(t, id.String(), info.GetMetadata()["peer_id"])
+			assert.Equal(t, method, info.GetMetadata()["method"])
+		}
+	}
+}
+
+func TestAuthorizationAllowPeers(t *testing.T) {
+	hosts := newHosts(t, 3)
+	srvHost, allowed, denied := hosts[0], hosts[1], hosts[2]
+	serveAuthorized(t, srvHost, libp2pgrpc.AllowPeers(allowed.ID()))
+
+	assert.NoError(t, callNode(dialNode(t, allowed, srvHost)))
+
+	deniedConn := dialNode(t, denied, srvHost)
+	assertDenied(t, callNode(deniedConn), denied.ID(), "/proto.v1.NodeService/Info")
+
+	stream, err := testpb.NewTestServiceClient(deniedConn).StreamingOutputCall(context.Background(),
+		&testpb.StreamingOutputCallRequest{ResponseParameters: []*testpb.ResponseParameters{{Size: 1}}})
+	assert.NoError(t, err)
+	_, err = stream.Recv()
+	assertDenied(t, err, denied.ID(), "/grpc.testing.TestService/StreamingOutputCall")
+}
+
+func TestAuthorizationAllowPublicKeys(t *testing.T) {
+	hosts := newHosts(t, 3)
+	srvHost, allowed, denied := hosts[0], hosts[1], hosts[2]
+
+	list := libp2pgrpc.AllowPublicKeys(allowed.Peerstore().PubKey(allowed.ID()))
+	serveAuthorized(t, srvHost, list)
+
+	assert.NoError(t, callNode(dialNode(t, allowed, srvHost)))
+	assertDenied(t, callNode(dialNode(t, denied, srvHost)), denied.ID(), "/proto.v1.NodeService/Info")
+
+	// the allowlist can change while serving
+	list.Add(denied.ID())
+	list.Remove(allowed.ID())
+	assert.NoError(t, callNode(dialNode(t, denied, srvHost)))
+	assertDenied(t, callNode(dialNode(t, allowed, srvHost)), allowed.ID(), "/proto.v1.NodeService/Info")
+}
+
+func TestAuthorizationPolicyFunc(t *testing.T) {
+	hosts := newHosts(t, 2)
+	srvHost, cliHost := hosts[0], hosts[1]
+
+	serveAuthorized(t, srvHost, libp2pgrpc.PolicyFunc(func(_ context.Context, info *libp2pgrpc.AuthInfo, fullMethod string) bool {
+		return info.ID == cliHost.ID() && fullMethod == "/proto.v1.NodeService/Info"
+	}))
+
+	conn := dialNode(t, cliHost, srvHost)
+	assert.NoError(t, callNode(conn))
+
+	_, err := testpb.NewTestServiceClient(conn).UnaryCall(context.Background(), &testpb.SimpleRequest{})
+	assertDenied(t, err, cliHost.ID(), "/grpc.testing.TestService/UnaryCall")
+}
+
+func TestConnectionGater(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 2)
+	allowed, denied := hosts[0], hosts[1]
+
+	gater := libp2pgrpc.NewConnectionGater(libp2pgrpc.AllowPeers(allowed.ID()))
+	srvHost, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"), libp2p.ConnectionGater(gater))
+	assert.NoError(t, err)
+	defer srvHost.Close()
+	serveAuthorized(t, srvHost, libp2pgrpc.AllowPeers(allowed.ID()))
+
+	info := peer.AddrInfo{ID: srvHost.ID(), Addrs: srvHost.Addrs()}
+	assert.NoError(t, allowed.Connect(ctx, info))
+	assert.Error(t, srvHost.Connect(ctx, peer.AddrInfo{ID: denied.ID(), Addrs: denied.Addrs()}))
+
+	allowed.Peerstore().AddAddrs(srvHost.ID(), srvHost.Addrs(), time.Minute)
+	assert.NoError(t, callNode(dialNode(t, allowed, srvHost)))
+
+	// inbound connections of the denied peer are closed once secured
+	denied.Peerstore().AddAddrs(srvHost.ID(), srvHost.Addrs(), time.Minute)
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	_, err = proto.NewNodeServiceClient(dialNode(t, denied, srvHost)).Info(ctx, &proto.NodeInfoRequest{})
+	assert.Error(t, err)
+}
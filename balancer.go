@@ -0,0 +1,215 @@
+package libp2pgrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+)
+
+// Load balancing policies understood by WithBalancer.
+const (
+	RoundRobin = "round_robin"
+	PickFirst  = "pick_first"
+)
+
+// peerSetScheme is the target scheme of connections created with
+// DialPeers. The peers come from a PeerSource, not from the target.
+const peerSetScheme = "libp2p-peers"
+
+// PeerSource provides the set of peers a ClientConn created with
+// DialPeers spreads its RPCs across.
+type PeerSource interface {
+	// Peers sends the current set of peers on the returned channel,
+	// followed by the full set every time it changes, until ctx is
+	// done.
+	Peers(ctx context.Context) (<-chan []peer.ID, error)
+}
+
+// PeerSourceFunc adapts a function to a PeerSource.
+type PeerSourceFunc func(ctx context.Context) (<-chan []peer.ID, error)
+
+func (f PeerSourceFunc) Peers(ctx context.Context) (<-chan []peer.ID, error) {
+	return f(ctx)
+}
+
+// StaticPeers returns a PeerSource for a fixed set of peers.
+func StaticPeers(ids ...peer.ID) PeerSource {
+	return PeerSourceFunc(func(context.Context) (<-chan []peer.ID, error) {
+		ch := make(chan []peer.ID, 1)
+		ch <- append([]peer.ID(nil), ids...)
+		return ch, nil
+	})
+}
+
+// WithBalancer returns a dial option selecting the load balancing
+// policy, e.g. RoundRobin or PickFirst, of a ClientConn created with
+// DialPeers.
+func WithBalancer(name string) grpc.DialOption {
+	return grpc.WithDefaultServiceConfig(fmt.Sprintf(`{"loadBalancingConfig":[{%q:{}}]}`, name))
+}
+
+// DialPeers creates a single gRPC client connection backed by every
+// peer in src, balancing RPCs round-robin across them unless another
+// policy is chosen with WithBalancer. A peer whose stream fails is
+// left out until gRPC manages to reconnect to it, which it tries as
+// soon as the peer is connected again.
+func (c *Client) DialPeers(ctx context.Context, src PeerSource, dialOpts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	peers := &peerSet{}
+	errs := newDialErrors()
+	opts := append([]grpc.DialOption{
+		c.dialer(c.dialRelayPolicy(dialOpts), errs),
+		grpc.WithResolvers(&peerSetResolverBuilder{client: c, source: src, peers: peers}),
+		grpc.WithTransportCredentials(NewCredentials()),
+		WithBalancer(RoundRobin),
+	}, relayErrorInterceptors(errs)...)
+	dialOpsPrepended := append(c.withStatsHandler(opts), dialOpts...)
+	conn, err := grpc.DialContext(ctx, peerSetScheme+":///peers", dialOpsPrepended...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.watch(conn, peers.has)
+	return conn, nil
+}
+
+// peerSet is the latest set of peers resolved for a connection.
+type peerSet struct {
+	mu  sync.Mutex
+	ids map[peer.ID]struct{}
+}
+
+func (s *peerSet) set(ids []peer.ID) {
+	m := make(map[peer.ID]struct{}, len(ids))
+	for _, id := range ids {
+		m[id] = struct{}{}
+	}
+
+	s.mu.Lock()
+	s.ids = m
+	s.mu.Unlock()
+}
+
+func (s *peerSet) has(id peer.ID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.ids[id]
+	return ok
+}
+
+type peerSetResolverBuilder struct {
+	client *Client
+	source PeerSource
+	peers  *peerSet
+}
+
+func (b *peerSetResolverBuilder) Scheme() string {
+	return peerSetScheme
+}
+
+func (b *peerSetResolverBuilder) Build(_ resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	updates, err := b.source.Peers(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	r := &peerSetResolver{
+		cc:      cc,
+		client:  b.client,
+		peers:   b.peers,
+		cancel:  cancel,
+		streams: newDialedStreams(),
+	}
+	go r.watch(ctx, updates)
+	b.client.watchUpgrades(r)
+	return r, nil
+}
+
+type peerSetResolver struct {
+	cc      resolver.ClientConn
+	client  *Client
+	peers   *peerSet
+	cancel  context.CancelFunc
+	streams *dialedStreams
+
+	// mu keeps the updates in order, whether they come from the peer
+	// source or from reconnect.
+	mu          sync.Mutex
+	ids         []peer.ID
+	generations map[peer.ID]int
+}
+
+func (r *peerSetResolver) watch(ctx context.Context, updates <-chan []peer.ID) {
+	for {
+		select {
+		case ids, ok := <-updates:
+			if !ok {
+				return
+			}
+			r.update(ids)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *peerSetResolver) update(ids []peer.ID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ids = ids
+	r.updateLocked()
+}
+
+func (r *peerSetResolver) updateLocked() {
+	r.peers.set(r.ids)
+
+	addrs := make([]resolver.Address, 0, len(r.ids))
+	for _, id := range r.ids {
+		attrs := attributes.New(protocolAttrKey{}, r.client.protocol).WithValue(dialedStreamsAttrKey{}, r.streams)
+		if gen := r.generations[id]; gen > 0 {
+			attrs = attrs.WithValue(generationAttrKey{}, gen)
+		}
+		addrs = append(addrs, resolver.Address{
+			Addr:       id.String(),
+			Attributes: attrs,
+		})
+	}
+	if len(addrs) == 0 {
+		r.cc.ReportError(errors.New("libp2pgrpc: peer source has no peers"))
+		return
+	}
+	r.cc.UpdateState(resolver.State{Addresses: addrs})
+}
+
+func (r *peerSetResolver) relayed(id peer.ID) bool {
+	return r.peers.has(id) && r.streams.relayed(id)
+}
+
+// reconnect makes gRPC move the connection to id to a new stream, as
+// peerResolver.reconnect does.
+func (r *peerSetResolver) reconnect(id peer.ID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.generations == nil {
+		r.generations = make(map[peer.ID]int)
+	}
+	r.generations[id]++
+	r.updateLocked()
+}
+
+func (r *peerSetResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (r *peerSetResolver) Close() {
+	r.cancel()
+	r.client.unwatchUpgrades(r)
+}
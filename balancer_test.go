@@ -0,0 +1,117 @@
+package libp2pgrpc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+
+	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
+	proto "github.com/drgomesp/go-libp2p-grpc/proto/v1"
+)
+
+func serveReplicas(t *testing.T, hosts []host.Host) []*libp2pgrpc.Server {
+	servers := make([]*libp2pgrpc.Server, len(hosts))
+	for i, h := range hosts {
+		srv, err := libp2pgrpc.NewGrpcServer(context.Background(), h)
+		assert.NoError(t, err)
+		t.Cleanup(srv.Stop)
+		serveNodeInfo(t, srv, h)
+		servers[i] = srv
+	}
+	return servers
+}
+
+// collectIDs issues n Info RPCs and returns how often each peer
+// answered. Failed RPCs are not counted.
+func collectIDs(conn grpc.ClientConnInterface, n int) map[string]int {
+	seen := make(map[string]int)
+	c := proto.NewNodeServiceClient(conn)
+	for i := 0; i < n; i++ {
+		res, err := c.Info(context.Background(), &proto.NodeInfoRequest{}, grpc.WaitForReady(true))
+		if err == nil {
+			seen[res.GetId()]++
+		}
+	}
+	return seen
+}
+
+func TestDialPeersRoundRobin(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 4)
+	cliHost, replicas := hosts[0], hosts[1:]
+	servers := serveReplicas(t, replicas)
+
+	ids := make([]peer.ID, len(replicas))
+	for i, h := range replicas {
+		ids[i] = h.ID()
+	}
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	conn, err := client.DialPeers(ctx, libp2pgrpc.StaticPeers(ids...))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// wait until every replica is connected, then check the spread
+	assert.Eventually(t, func() bool {
+		return len(collectIDs(conn, 3)) == 3
+	}, 5*time.Second, 50*time.Millisecond)
+	seen := collectIDs(conn, 9)
+	for _, h := range replicas {
+		assert.Equal(t, 3, seen[h.ID().String()])
+	}
+
+	// a stopped replica no longer receives RPCs
+	servers[0].Stop()
+	assert.Eventually(t, func() bool {
+		seen := collectIDs(conn, 6)
+		return len(seen) == 2 && seen[replicas[0].ID().String()] == 0
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestDialPeersPickFirst(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 3)
+	cliHost, replicas := hosts[0], hosts[1:]
+	serveReplicas(t, replicas)
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	conn, err := client.DialPeers(ctx,
+		libp2pgrpc.StaticPeers(replicas[0].ID(), replicas[1].ID()),
+		libp2pgrpc.WithBalancer(libp2pgrpc.PickFirst),
+	)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	seen := collectIDs(conn, 4)
+	assert.Equal(t, map[string]int{replicas[0].ID().String(): 4}, seen)
+}
+
+func TestDialPeersDynamicSource(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 3)
+	cliHost, replicas := hosts[0], hosts[1:]
+	serveReplicas(t, replicas)
+
+	updates := make(chan []peer.ID, 1)
+	updates <- []peer.ID{replicas[0].ID()}
+	src := libp2pgrpc.PeerSourceFunc(func(context.Context) (<-chan []peer.ID, error) {
+		return updates, nil
+	})
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	conn, err := client.DialPeers(ctx, src)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	assert.Equal(t, map[string]int{replicas[0].ID().String(): 2}, collectIDs(conn, 2))
+
+	updates <- []peer.ID{replicas[0].ID(), replicas[1].ID()}
+	assert.Eventually(t, func() bool {
+		return len(collectIDs(conn, 4)) == 2
+	}, 5*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,215 @@
+package libp2pgrpc_test
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+
+	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
+)
+
+// benchmarkMaxMsgSize lifts the default 4MiB message limit of gRPC, so
+// the largest messages fit.
+const benchmarkMaxMsgSize = 128 << 20
+
+var benchmarkMessageSizes = []struct {
+	name string
+	size int
+}{
+	{"1MB", 1e6},
+	{"8MB", 8e6},
+	{"64MB", 64e6},
+}
+
+type benchmarkConn struct {
+	name string
+	conn grpc.ClientConnInterface
+}
+
+// benchmarkConns returns connections to peers serving the test service
+// over every setup being compared: plain gRPC over TCP as the baseline,
+// then libp2p over mocknet, TCP and QUIC, with both the HTTP/2 and the
+// stream transport.
+func benchmarkConns(b *testing.B) []benchmarkConn {
+	conns := []benchmarkConn{{"grpc-tcp", dialPlainGrpc(b)}}
+
+	mn, err := mocknet.FullMeshConnected(2)
+	assert.NoError(b, err)
+	b.Cleanup(func() { mn.Close() })
+
+	setups := []struct {
+		name  string
+		hosts []host.Host
+	}{
+		{"mocknet", mn.Hosts()},
+		{"tcp", newBenchmarkHosts(b, "/ip4/127.0.0.1/tcp/0")},
+		{"quic", newBenchmarkHosts(b, "/ip4/127.0.0.1/udp/0/quic-v1")},
+	}
+	for _, setup := range setups {
+		srvHost, cliHost := setup.hosts[0], setup.hosts[1]
+		serveStreamTransport(b, srvHost, libp2pgrpc.WithMaxRecvMsgSize(benchmarkMaxMsgSize), grpc.MaxSendMsgSize(benchmarkMaxMsgSize))
+
+		client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+		http2, err := client.Dial(context.Background(), srvHost.ID(), grpc.WithBlock(), benchmarkCallOptions())
+		assert.NoError(b, err)
+		b.Cleanup(func() { http2.Close() })
+
+		conns = append(conns,
+			benchmarkConn{setup.name + "/http2", http2},
+			benchmarkConn{setup.name + "/streams", client.DialStreams(srvHost.ID())},
+		)
+	}
+	return conns
+}
+
+// newBenchmarkHosts returns two hosts listening on the given address
+// and knowing each other's addresses.
+func newBenchmarkHosts(b *testing.B, listen string) []host.Host {
+	hosts := make([]host.Host, 2)
+	for i := range hosts {
+		h := newHost(b, multiaddr.StringCast(listen))
+		b.Cleanup(func() { h.Close() })
+		hosts[i] = h
+	}
+	hosts[1].Peerstore().AddAddrs(hosts[0].ID(), hosts[0].Addrs(), peerstore.PermanentAddrTTL)
+	return hosts
+}
+
+// dialPlainGrpc serves the test service with plain gRPC over loopback
+// TCP and dials it.
+func dialPlainGrpc(b *testing.B) *grpc.ClientConn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(b, err)
+
+	srv := grpc.NewServer(grpc.MaxRecvMsgSize(benchmarkMaxMsgSize), grpc.MaxSendMsgSize(benchmarkMaxMsgSize))
+	testpb.RegisterTestServiceServer(srv, streamsTestService{})
+	go srv.Serve(listener)
+	b.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithBlock(), benchmarkCallOptions())
+	assert.NoError(b, err)
+	b.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func benchmarkCallOptions() grpc.DialOption {
+	return grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(benchmarkMaxMsgSize), grpc.MaxCallSendMsgSize(benchmarkMaxMsgSize))
+}
+
+func benchmarkUnary(b *testing.B, conn grpc.ClientConnInterface, size int, parallel bool) {
+	client := testpb.NewTestServiceClient(conn)
+	req := &testpb.SimpleRequest{Payload: &testpb.Payload{Body: bytes.Repeat([]byte{'a'}, size)}}
+	call := func() {
+		if _, err := client.UnaryCall(context.Background(), req); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	// the payload goes both ways
+	b.SetBytes(int64(2 * size))
+	b.ResetTimer()
+	if !parallel {
+		for i := 0; i < b.N; i++ {
+			call()
+		}
+		return
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			call()
+		}
+	})
+}
+
+// BenchmarkUnary measures the latency of small unary calls, one at a
+// time and concurrently.
+func BenchmarkUnary(b *testing.B) {
+	for _, c := range benchmarkConns(b) {
+		c := c
+		b.Run(c.name, func(b *testing.B) {
+			benchmarkUnary(b, c.conn, 64, false)
+		})
+		b.Run(c.name+"/parallel", func(b *testing.B) {
+			benchmarkUnary(b, c.conn, 64, true)
+		})
+	}
+}
+
+// BenchmarkLargeMessages measures the throughput of unary calls
+// carrying large messages.
+func BenchmarkLargeMessages(b *testing.B) {
+	for _, c := range benchmarkConns(b) {
+		for _, msg := range benchmarkMessageSizes {
+			c, msg := c, msg
+			b.Run(c.name+"/"+msg.name, func(b *testing.B) {
+				benchmarkUnary(b, c.conn, msg.size, false)
+			})
+		}
+	}
+}
+
+// BenchmarkServerStreaming measures the throughput of a server stream
+// of 64KiB messages.
+func BenchmarkServerStreaming(b *testing.B) {
+	const size = 64 << 10
+
+	for _, c := range benchmarkConns(b) {
+		c := c
+		b.Run(c.name, func(b *testing.B) {
+			client := testpb.NewTestServiceClient(c.conn)
+			params := make([]*testpb.ResponseParameters, b.N)
+			for i := range params {
+				params[i] = &testpb.ResponseParameters{Size: size}
+			}
+
+			b.SetBytes(size)
+			b.ResetTimer()
+			stream, err := client.StreamingOutputCall(context.Background(), &testpb.StreamingOutputCallRequest{ResponseParameters: params})
+			if err != nil {
+				b.Fatal(err)
+			}
+			for i := 0; i < b.N; i++ {
+				if _, err := stream.Recv(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkPingPong measures the round trip of a message on an
+// established bidirectional stream.
+func BenchmarkPingPong(b *testing.B) {
+	for _, c := range benchmarkConns(b) {
+		c := c
+		b.Run(c.name, func(b *testing.B) {
+			stream, err := testpb.NewTestServiceClient(c.conn).FullDuplexCall(context.Background())
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer stream.CloseSend()
+			req := &testpb.StreamingOutputCallRequest{Payload: &testpb.Payload{Body: []byte("ping")}}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := stream.Send(req); err != nil {
+					b.Fatal(err)
+				}
+				if _, err := stream.Recv(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
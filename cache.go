@@ -0,0 +1,145 @@
+package libp2pgrpc
+
+import (
+	"container/list"
+	"io"
+	"sync"
+	"time"
+)
+
+// connCache holds the connections of the pool of a Client and of a
+// Gateway. Past maxConns, it closes the least recently used connections
+// without calls in flight; with an idleTimeout, it closes those without
+// calls for that long.
+type connCache struct {
+	maxConns    int
+	idleTimeout time.Duration
+	done        chan struct{}
+
+	mu      sync.Mutex
+	closed  bool
+	entries map[interface{}]*cacheEntry
+	lru     *list.List
+}
+
+// cacheEntry is a connection of a connCache. conn is nil until the
+// owner of the cache has set up the connection.
+type cacheEntry struct {
+	key interface{}
+	// value is what the owner of the cache keeps with the connection.
+	value    interface{}
+	conn     io.Closer
+	active   int
+	lastUsed time.Time
+	elem     *list.Element
+}
+
+func newConnCache(maxConns int, idleTimeout time.Duration) *connCache {
+	c := &connCache{
+		maxConns:    maxConns,
+		idleTimeout: idleTimeout,
+		done:        make(chan struct{}),
+		entries:     make(map[interface{}]*cacheEntry),
+		lru:         list.New(),
+	}
+	if idleTimeout > 0 {
+		go c.evictIdle()
+	}
+	return c
+}
+
+// addLocked adds an entry for key, without a connection yet.
+func (c *connCache) addLocked(key, value interface{}) *cacheEntry {
+	entry := &cacheEntry{key: key, value: value, lastUsed: time.Now()}
+	entry.elem = c.lru.PushFront(entry)
+	c.entries[key] = entry
+	return entry
+}
+
+// touchLocked marks entry as the most recently used, and evicts the
+// entries past maxConns. entry itself is never evicted.
+func (c *connCache) touchLocked(entry *cacheEntry) {
+	entry.lastUsed = time.Now()
+	c.lru.MoveToFront(entry.elem)
+	c.evictLocked(entry)
+}
+
+// begin and end count the calls in flight on the connection of entry,
+// which is not evicted until they are over.
+func (c *connCache) begin(entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry.active++
+	entry.lastUsed = time.Now()
+}
+
+func (c *connCache) end(entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry.active--
+	entry.lastUsed = time.Now()
+	c.evictLocked(nil)
+}
+
+// evictLocked closes least recently used idle connections until the
+// cache fits maxConns. keep is never evicted.
+func (c *connCache) evictLocked(keep *cacheEntry) {
+	if c.maxConns <= 0 {
+		return
+	}
+	for e := c.lru.Back(); e != nil && len(c.entries) > c.maxConns; {
+		entry := e.Value.(*cacheEntry)
+		e = e.Prev()
+		if entry != keep && entry.active == 0 {
+			c.removeLocked(entry)
+		}
+	}
+}
+
+func (c *connCache) evictIdle() {
+	ticker := time.NewTicker(c.idleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			c.mu.Lock()
+			for _, entry := range c.entries {
+				if entry.active == 0 && now.Sub(entry.lastUsed) >= c.idleTimeout {
+					c.removeLocked(entry)
+				}
+			}
+			c.mu.Unlock()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *connCache) removeLocked(entry *cacheEntry) {
+	delete(c.entries, entry.key)
+	c.lru.Remove(entry.elem)
+	if entry.conn != nil {
+		entry.conn.Close()
+	}
+}
+
+// close closes every connection, and reports whether the cache was
+// open until then.
+func (c *connCache) close() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+	c.closed = true
+	close(c.done)
+
+	for _, entry := range c.entries {
+		c.removeLocked(entry)
+	}
+	return true
+}
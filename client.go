@@ -1,13 +1,20 @@
 package libp2pgrpc
 
 import (
+	"sync"
+	"time"
+
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/protocol"
+	"google.golang.org/grpc/stats"
 )
 
 // ClientOption allows for functional setting of options on a Client.
 type ClientOption func(*Client)
 
+// WithServer lets the client reach s in-process. Dialing the peer ID
+// of the server's host, on one of the server's protocols, connects to
+// s through an in-memory pipe instead of a libp2p stream.
 func WithServer(s *Server) ClientOption {
 	return func(c *Client) {
 		c.server = s
@@ -18,12 +25,26 @@ type Client struct {
 	host     host.Host
 	protocol protocol.ID
 	server   *Server
+
+	statsHandler stats.Handler
+	metrics      MetricsTracer
+	relayPolicy  RelayPolicy
+
+	discoveryInterval time.Duration
+
+	maxConns        int
+	connIdleTimeout time.Duration
+	poolOnce        sync.Once
+	pool            *connPool
+	watcherMu       sync.Mutex
+	watcher         *connWatcher
 }
 
 func NewClient(h host.Host, p protocol.ID, opts ...ClientOption) *Client {
 	c := &Client{
-		host:     h,
-		protocol: p,
+		host:            h,
+		protocol:        p,
+		connIdleTimeout: DefaultConnIdleTimeout,
 	}
 
 	for _, opt := range opts {
@@ -0,0 +1,209 @@
+package libp2pgrpc
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// clientTransport carries the response of a clientStream.
+type clientTransport interface {
+	// nextFrame reads the next frame of the response and returns it if
+	// it is a message. Other frames set the header or end the call.
+	nextFrame() ([]byte, bool)
+	// release releases the transport once the call has ended with err.
+	release(err error)
+}
+
+// clientStream is the client side of an RPC, whichever the transport
+// that carries its frames. StreamConn and GrpcWebConn streams embed it.
+type clientStream struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	codec     encoding.Codec
+	transport clientTransport
+
+	headerAddr  *metadata.MD
+	trailerAddr *metadata.MD
+
+	header     metadata.MD
+	headerDone bool
+	pending    []byte
+	trailer    metadata.MD
+
+	mu sync.Mutex
+	// err is the outcome of the call once it is known, io.EOF meaning
+	// the call succeeded.
+	err error
+}
+
+func newClientStream(ctx context.Context, cancel context.CancelFunc, codec encoding.Codec, opts []grpc.CallOption) *clientStream {
+	s := &clientStream{ctx: ctx, cancel: cancel, codec: codec}
+	for _, opt := range opts {
+		switch o := opt.(type) {
+		case grpc.HeaderCallOption:
+			s.headerAddr = o.HeaderAddr
+		case grpc.TrailerCallOption:
+			s.trailerAddr = o.TrailerAddr
+		}
+	}
+	return s
+}
+
+// invoke performs a unary RPC on stream.
+func invoke(stream interface {
+	grpc.ClientStream
+	finish() error
+}, args, reply interface{}) error {
+	// a failed write leaves the status of the call to RecvMsg
+	if err := stream.SendMsg(args); err != nil && err != io.EOF {
+		return err
+	}
+	_ = stream.CloseSend()
+	if err := stream.RecvMsg(reply); err != nil {
+		if err == io.EOF {
+			return status.Error(codes.Internal, "libp2pgrpc: no response to unary call")
+		}
+		return err
+	}
+	return stream.finish()
+}
+
+func (s *clientStream) Header() (metadata.MD, error) {
+	for !s.headerDone {
+		data, ok := s.recv()
+		if !ok {
+			break
+		}
+		// keep the message for RecvMsg
+		s.pending = data
+	}
+	if s.header == nil {
+		if err := s.outcome(); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+	return s.header, nil
+}
+
+func (s *clientStream) Trailer() metadata.MD {
+	return s.trailer
+}
+
+func (s *clientStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *clientStream) RecvMsg(m interface{}) error {
+	data, ok := s.recv()
+	if !ok {
+		return s.outcome()
+	}
+	if err := s.codec.Unmarshal(data, m); err != nil {
+		s.end(nil, status.Errorf(codes.Internal, "libp2pgrpc: unmarshaling response: %s", err))
+		return s.outcome()
+	}
+	return nil
+}
+
+// finish reads the trailers after the single response of a unary call.
+func (s *clientStream) finish() error {
+	if _, ok := s.recv(); ok {
+		s.end(nil, status.Error(codes.Internal, "libp2pgrpc: more than one response to unary call"))
+	}
+	if err := s.outcome(); err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// recv returns the next response message. Once the call has ended, it
+// returns false and outcome holds the outcome.
+func (s *clientStream) recv() ([]byte, bool) {
+	if data := s.pending; data != nil {
+		s.pending = nil
+		return data, true
+	}
+	for s.outcome() == nil {
+		if data, ok := s.transport.nextFrame(); ok {
+			return data, true
+		}
+	}
+	return nil, false
+}
+
+// setHeader records the response header, nil meaning the response has
+// none. Only the first header counts.
+func (s *clientStream) setHeader(md metadata.MD) {
+	if s.headerDone {
+		return
+	}
+	s.headerDone = true
+	if md != nil {
+		s.header = md
+		if s.headerAddr != nil {
+			*s.headerAddr = md
+		}
+	}
+}
+
+func (s *clientStream) outcome() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+// end records the outcome of the call and releases its resources. A
+// nil err records a successful call.
+func (s *clientStream) end(trailer metadata.MD, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return
+	}
+	if err == nil {
+		err = io.EOF
+	}
+	s.err = err
+
+	if trailer != nil {
+		s.trailer = trailer
+		if s.trailerAddr != nil {
+			*s.trailerAddr = trailer
+		}
+	}
+	s.transport.release(err)
+	s.cancel()
+}
+
+func (s *clientStream) transportError(err error) error {
+	if ctxErr := s.ctx.Err(); ctxErr != nil {
+		return status.FromContextError(ctxErr).Err()
+	}
+	return status.Error(codes.Unavailable, err.Error())
+}
+
+// callStatus returns the status of a call from the code, message and
+// details of its trailer, nil if it succeeded.
+func callStatus(code codes.Code, msg string, details *spb.Status) error {
+	if code == codes.OK {
+		return nil
+	}
+	if details != nil && codes.Code(details.GetCode()) == code {
+		st := status.FromProto(details)
+		if relayErr, ok := transientRefusal(st); ok {
+			return relayErr
+		}
+		return st.Err()
+	}
+	return status.Error(code, msg)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func listServices(src *reflectionSource, w io.Writer) error {
+	names, err := src.Services()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		fmt.Fprintln(w, name)
+	}
+	return nil
+}
+
+func listMethods(src *reflectionSource, service string, w io.Writer) error {
+	d, err := src.FindSymbol(service)
+	if err != nil {
+		return err
+	}
+	sd, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return fmt.Errorf("%s is not a service", service)
+	}
+
+	methods := sd.Methods()
+	for i := 0; i < methods.Len(); i++ {
+		fmt.Fprintln(w, methods.Get(i).FullName())
+	}
+	return nil
+}
+
+// describe prints a descriptor in protobuf source form.
+func describe(src *reflectionSource, symbol string, w io.Writer) error {
+	d, err := src.FindSymbol(symbol)
+	if err != nil {
+		return err
+	}
+
+	switch d := d.(type) {
+	case protoreflect.ServiceDescriptor:
+		fmt.Fprintf(w, "%s is a service:\n", d.FullName())
+		fmt.Fprintf(w, "service %s {\n", d.Name())
+		methods := d.Methods()
+		for i := 0; i < methods.Len(); i++ {
+			fmt.Fprintf(w, "  %s\n", methodSignature(methods.Get(i)))
+		}
+		fmt.Fprintln(w, "}")
+	case protoreflect.MethodDescriptor:
+		fmt.Fprintf(w, "%s is a method:\n", d.FullName())
+		fmt.Fprintln(w, methodSignature(d))
+	case protoreflect.MessageDescriptor:
+		fmt.Fprintf(w, "%s is a message:\n", d.FullName())
+		fmt.Fprintf(w, "message %s {\n", d.Name())
+		fields := d.Fields()
+		for i := 0; i < fields.Len(); i++ {
+			fmt.Fprintf(w, "  %s\n", fieldSignature(fields.Get(i)))
+		}
+		fmt.Fprintln(w, "}")
+	case protoreflect.EnumDescriptor:
+		fmt.Fprintf(w, "%s is an enum:\n", d.FullName())
+		fmt.Fprintf(w, "enum %s {\n", d.Name())
+		values := d.Values()
+		for i := 0; i < values.Len(); i++ {
+			fmt.Fprintf(w, "  %s = %d;\n", values.Get(i).Name(), values.Get(i).Number())
+		}
+		fmt.Fprintln(w, "}")
+	default:
+		return fmt.Errorf("cannot describe %s", symbol)
+	}
+	return nil
+}
+
+func methodSignature(m protoreflect.MethodDescriptor) string {
+	in, out := "", ""
+	if m.IsStreamingClient() {
+		in = "stream "
+	}
+	if m.IsStreamingServer() {
+		out = "stream "
+	}
+	return fmt.Sprintf("rpc %s ( %s.%s ) returns ( %s.%s );", m.Name(), in, m.Input().FullName(), out, m.Output().FullName())
+}
+
+func fieldSignature(f protoreflect.FieldDescriptor) string {
+	var typ string
+	switch {
+	case f.IsMap():
+		typ = fmt.Sprintf("map<%s, %s>", kindName(f.MapKey()), kindName(f.MapValue()))
+	case f.Cardinality() == protoreflect.Repeated:
+		typ = "repeated " + kindName(f)
+	default:
+		typ = kindName(f)
+	}
+	return fmt.Sprintf("%s %s = %d;", typ, f.Name(), f.Number())
+}
+
+func kindName(f protoreflect.FieldDescriptor) string {
+	switch f.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return "." + string(f.Message().FullName())
+	case protoreflect.EnumKind:
+		return "." + string(f.Enum().FullName())
+	}
+	return f.Kind().String()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// invoke calls a method with the JSON requests read from in, writing
+// every response to out as JSON. Unary and server streaming methods
+// take exactly one request, an empty one if in has none, as grpcurl
+// does; client and bidi streaming methods take any number of them.
+func invoke(ctx context.Context, conn grpc.ClientConnInterface, src *reflectionSource, method string, in io.Reader, out io.Writer) error {
+	md, err := findMethod(src, method)
+	if err != nil {
+		return err
+	}
+
+	requests, err := readRequests(md.Input(), in)
+	if err != nil {
+		return err
+	}
+	if !md.IsStreamingClient() && len(requests) == 0 {
+		requests = append(requests, dynamicpb.NewMessage(md.Input()))
+	}
+	if !md.IsStreamingClient() && len(requests) != 1 {
+		return fmt.Errorf("%s takes exactly one request, got %d", md.FullName(), len(requests))
+	}
+
+	desc := &grpc.StreamDesc{
+		StreamName:    string(md.Name()),
+		ServerStreams: md.IsStreamingServer(),
+		ClientStreams: md.IsStreamingClient(),
+	}
+	fullMethod := fmt.Sprintf("/%s/%s", md.Parent().FullName(), md.Name())
+	stream, err := conn.NewStream(ctx, desc, fullMethod)
+	if err != nil {
+		return err
+	}
+
+	for _, req := range requests {
+		if err := stream.SendMsg(req); err != nil {
+			break // the status is reported by RecvMsg
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	marshaler := protojson.MarshalOptions{Multiline: true, Indent: "  "}
+	for {
+		res := dynamicpb.NewMessage(md.Output())
+		if err := stream.RecvMsg(res); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		data, err := marshaler.Marshal(res)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(out, string(data))
+	}
+}
+
+// findMethod accepts "pkg.Service/Method" and "pkg.Service.Method".
+func findMethod(src *reflectionSource, method string) (protoreflect.MethodDescriptor, error) {
+	name := strings.TrimPrefix(method, "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[:i] + "." + name[i+1:]
+	}
+
+	d, err := src.FindSymbol(name)
+	if err != nil {
+		return nil, err
+	}
+	md, ok := d.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a method", method)
+	}
+	return md, nil
+}
+
+// readRequests decodes a stream of JSON objects into messages of the
+// given type.
+func readRequests(typ protoreflect.MessageDescriptor, in io.Reader) ([]*dynamicpb.Message, error) {
+	dec := json.NewDecoder(in)
+
+	var requests []*dynamicpb.Message
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("invalid request data: %w", err)
+		}
+
+		req := dynamicpb.NewMessage(typ)
+		if err := protojson.Unmarshal(raw, req); err != nil {
+			return nil, fmt.Errorf("invalid request data: %w", err)
+		}
+		requests = append(requests, req)
+	}
+
+	return requests, nil
+}
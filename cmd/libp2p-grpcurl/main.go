@@ -0,0 +1,168 @@
+// Command libp2p-grpcurl talks to gRPC services over libp2p, much like
+// grpcurl does over TCP. It creates an ephemeral libp2p host, dials the
+// target through libp2pgrpc.Client and uses server reflection to list
+// services, describe symbols and invoke methods with JSON messages.
+//
+// Usage:
+//
+//	libp2p-grpcurl [flags] <target> list [service]
+//	libp2p-grpcurl [flags] <target> describe <symbol>
+//	libp2p-grpcurl [flags] -d '{"json": "message"}' <target> <service>/<method>
+//
+// The target is a multiaddr ending in /p2p/<peerID>, or a bare peer ID
+// when the ephemeral host can find the peer on its own.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multiaddr"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
+)
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	os.Exit(run(ctx, os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// headerFlags collects repeated -H flags.
+type headerFlags []string
+
+func (h *headerFlags) String() string { return strings.Join(*h, ", ") }
+
+func (h *headerFlags) Set(v string) error {
+	if !strings.Contains(v, ":") {
+		return fmt.Errorf("header %q must have the form 'name: value'", v)
+	}
+	*h = append(*h, v)
+	return nil
+}
+
+// run executes the command line and returns the process exit code.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("libp2p-grpcurl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	protocolF := fs.String("protocol", string(libp2pgrpc.ProtocolID), "libp2p protocol ID of the gRPC server")
+	dataF := fs.String("d", "", "JSON request data; '@' reads the requests from stdin")
+	timeoutF := fs.Duration("timeout", 30*time.Second, "timeout for the whole operation")
+	var headers headerFlags
+	fs.Var(&headers, "H", "request metadata as 'name: value'; may be repeated")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage:")
+		fmt.Fprintln(stderr, "  libp2p-grpcurl [flags] <target> list [service]")
+		fmt.Fprintln(stderr, "  libp2p-grpcurl [flags] <target> describe <symbol>")
+		fmt.Fprintln(stderr, "  libp2p-grpcurl [flags] <target> <service>/<method>")
+		fmt.Fprintln(stderr, "Flags:")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeoutF)
+	defer cancel()
+
+	if err := execute(ctx, fs.Args(), protocol.ID(*protocolF), *dataF, headers, stdin, stdout); err != nil {
+		if st, ok := status.FromError(err); ok && st.Code() != 0 {
+			fmt.Fprintf(stderr, "ERROR:\n  Code: %s\n  Message: %s\n", st.Code(), st.Message())
+		} else {
+			fmt.Fprintf(stderr, "ERROR: %v\n", err)
+		}
+		return 1
+	}
+	return 0
+}
+
+func execute(ctx context.Context, args []string, p protocol.ID, data string, headers []string, stdin io.Reader, stdout io.Writer) error {
+	h, err := libp2p.New(libp2p.NoListenAddrs)
+	if err != nil {
+		return err
+	}
+	defer h.Close()
+
+	peerID, err := addTarget(h, args[0])
+	if err != nil {
+		return err
+	}
+
+	client := libp2pgrpc.NewClient(h, p)
+	conn, err := client.Dial(ctx, peerID, grpc.WithBlock())
+	if err != nil {
+		return fmt.Errorf("failed to dial %s: %w", peerID, err)
+	}
+	defer conn.Close()
+
+	src := newReflectionSource(ctx, conn)
+	defer src.Close()
+
+	switch args[1] {
+	case "list":
+		if len(args) > 2 {
+			return listMethods(src, args[2], stdout)
+		}
+		return listServices(src, stdout)
+	case "describe":
+		if len(args) < 3 {
+			return errors.New("describe needs a symbol")
+		}
+		return describe(src, args[2], stdout)
+	}
+
+	var in io.Reader = strings.NewReader(data)
+	if data == "@" {
+		in = stdin
+	}
+	ctx = metadata.NewOutgoingContext(ctx, parseHeaders(headers))
+	return invoke(ctx, conn, src, args[1], in, stdout)
+}
+
+// addTarget adds the addresses found in target to the peerstore and
+// returns the peer ID it names.
+func addTarget(h host.Host, target string) (peer.ID, error) {
+	if !strings.HasPrefix(target, "/") {
+		return peer.Decode(target)
+	}
+
+	maddr, err := multiaddr.NewMultiaddr(target)
+	if err != nil {
+		return "", err
+	}
+	info, err := peer.AddrInfoFromP2pAddr(maddr)
+	if err != nil {
+		return "", err
+	}
+	h.Peerstore().AddAddrs(info.ID, info.Addrs, peerstore.TempAddrTTL)
+	return info.ID, nil
+}
+
+func parseHeaders(headers []string) metadata.MD {
+	md := metadata.MD{}
+	for _, header := range headers {
+		name, value, _ := strings.Cut(header, ":")
+		md.Append(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	return md
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+
+	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
+)
+
+type testService struct {
+	testpb.UnimplementedTestServiceServer
+}
+
+func (testService) UnaryCall(_ context.Context, req *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	return &testpb.SimpleResponse{Payload: req.GetPayload(), Username: "libp2p"}, nil
+}
+
+func (testService) StreamingOutputCall(req *testpb.StreamingOutputCallRequest, stream testpb.TestService_StreamingOutputCallServer) error {
+	for _, params := range req.GetResponseParameters() {
+		payload := &testpb.Payload{Body: bytes.Repeat([]byte{'a'}, int(params.GetSize()))}
+		if err := stream.Send(&testpb.StreamingOutputCallResponse{Payload: payload}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (testService) FullDuplexCall(stream testpb.TestService_FullDuplexCallServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&testpb.StreamingOutputCallResponse{Payload: req.GetPayload()}); err != nil {
+			return err
+		}
+	}
+}
+
+func startServer(t *testing.T) string {
+	h, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	assert.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+
+	srv, err := libp2pgrpc.NewGrpcServer(context.Background(), h, libp2pgrpc.WithReflection())
+	assert.NoError(t, err)
+	t.Cleanup(srv.Stop)
+	testpb.RegisterTestServiceServer(srv, testService{})
+	go srv.Serve()
+
+	return targetOf(t, h)
+}
+
+func targetOf(t *testing.T, h host.Host) string {
+	addrs, err := peer.AddrInfoToP2pAddrs(&peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()})
+	assert.NoError(t, err)
+	return addrs[0].String()
+}
+
+func runCLI(t *testing.T, stdin string, args ...string) (string, string, int) {
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr)
+	return stdout.String(), stderr.String(), code
+}
+
+func TestList(t *testing.T) {
+	target := startServer(t)
+
+	out, errOut, code := runCLI(t, "", target, "list")
+	assert.Equal(t, 0, code, errOut)
+	assert.Equal(t, "grpc.reflection.v1.ServerReflection\ngrpc.reflection.v1alpha.ServerReflection\ngrpc.testing.TestService\n", out)
+
+	out, errOut, code = runCLI(t, "", target, "list", "grpc.testing.TestService")
+	assert.Equal(t, 0, code, errOut)
+	assert.Contains(t, out, "grpc.testing.TestService.UnaryCall\n")
+	assert.Contains(t, out, "grpc.testing.TestService.FullDuplexCall\n")
+}
+
+func TestDescribe(t *testing.T) {
+	target := startServer(t)
+
+	out, errOut, code := runCLI(t, "", target, "describe", "grpc.testing.TestService")
+	assert.Equal(t, 0, code, errOut)
+	assert.Contains(t, out, "service TestService {\n")
+	assert.Contains(t, out, "rpc FullDuplexCall ( stream .grpc.testing.StreamingOutputCallRequest ) returns ( stream .grpc.testing.StreamingOutputCallResponse );")
+
+	out, errOut, code = runCLI(t, "", target, "describe", "grpc.testing.SimpleResponse")
+	assert.Equal(t, 0, code, errOut)
+	assert.Contains(t, out, "message SimpleResponse {\n")
+	assert.Contains(t, out, "  .grpc.testing.Payload payload = 1;\n")
+	assert.Contains(t, out, "  string username = 2;\n")
+}
+
+func TestInvokeUnary(t *testing.T) {
+	target := startServer(t)
+
+	out, errOut, code := runCLI(t, "", "-d", `{"payload": {"body": "aGk="}}`, target, "grpc.testing.TestService/UnaryCall")
+	assert.Equal(t, 0, code, errOut)
+
+	var res map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(out), &res))
+	assert.Equal(t, "libp2p", res["username"])
+	assert.Equal(t, map[string]interface{}{"body": "aGk="}, res["payload"])
+}
+
+func TestInvokeEmptyData(t *testing.T) {
+	target := startServer(t)
+
+	// an empty request, as with grpcurl
+	out, errOut, code := runCLI(t, "", "-d", "", target, "grpc.testing.TestService/UnaryCall")
+	assert.Equal(t, 0, code, errOut)
+	assert.JSONEq(t, `{"username": "libp2p"}`, out)
+
+	// no request at all
+	out, errOut, code = runCLI(t, "", "-d", "", target, "grpc.testing.TestService/FullDuplexCall")
+	assert.Equal(t, 0, code, errOut)
+	assert.Empty(t, out)
+}
+
+func TestInvokeStreaming(t *testing.T) {
+	target := startServer(t)
+
+	out, errOut, code := runCLI(t, "",
+		"-d", `{"responseParameters": [{"size": 1}, {"size": 2}, {"size": 3}]}`,
+		target, "grpc.testing.TestService/StreamingOutputCall",
+	)
+	assert.Equal(t, 0, code, errOut)
+	assert.Equal(t, 3, strings.Count(out, `"payload"`))
+
+	stdin := `{"payload": {"body": "MQ=="}} {"payload": {"body": "Mg=="}}`
+	out, errOut, code = runCLI(t, stdin, "-d", "@", target, "grpc.testing.TestService.FullDuplexCall")
+	assert.Equal(t, 0, code, errOut)
+	assert.Contains(t, out, `"MQ=="`)
+	assert.Contains(t, out, `"Mg=="`)
+}
+
+func TestInvokeError(t *testing.T) {
+	target := startServer(t)
+
+	_, errOut, code := runCLI(t, "", target, "grpc.testing.TestService/EmptyCall")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, errOut, "Code: Unimplemented")
+}
+
+func TestUsage(t *testing.T) {
+	_, errOut, code := runCLI(t, "", "only-a-target")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, errOut, "Usage:")
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"google.golang.org/grpc"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// reflectionSource resolves descriptors through the server reflection
+// service of the remote peer.
+type reflectionSource struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	client reflectionpb.ServerReflectionClient
+	stream reflectionpb.ServerReflection_ServerReflectionInfoClient
+
+	// files holds every file descriptor fetched so far, by name.
+	files map[string]*descriptorpb.FileDescriptorProto
+}
+
+func newReflectionSource(ctx context.Context, conn grpc.ClientConnInterface) *reflectionSource {
+	ctx, cancel := context.WithCancel(ctx)
+	return &reflectionSource{
+		ctx:    ctx,
+		cancel: cancel,
+		client: reflectionpb.NewServerReflectionClient(conn),
+		files:  make(map[string]*descriptorpb.FileDescriptorProto),
+	}
+}
+
+func (s *reflectionSource) Close() {
+	if s.stream != nil {
+		s.stream.CloseSend()
+	}
+	s.cancel()
+}
+
+func (s *reflectionSource) call(req *reflectionpb.ServerReflectionRequest) (*reflectionpb.ServerReflectionResponse, error) {
+	if s.stream == nil {
+		stream, err := s.client.ServerReflectionInfo(s.ctx)
+		if err != nil {
+			return nil, err
+		}
+		s.stream = stream
+	}
+
+	if err := s.stream.Send(req); err != nil {
+		return nil, err
+	}
+	res, err := s.stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+	if errRes := res.GetErrorResponse(); errRes != nil {
+		return nil, fmt.Errorf("reflection error %d: %s", errRes.GetErrorCode(), errRes.GetErrorMessage())
+	}
+	return res, nil
+}
+
+// Services returns the sorted names of the services the peer offers.
+func (s *reflectionSource) Services() ([]string, error) {
+	res, err := s.call(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0)
+	for _, svc := range res.GetListServicesResponse().GetService() {
+		names = append(names, svc.GetName())
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// FindSymbol returns the descriptor of a fully qualified service,
+// method, message or enum name.
+func (s *reflectionSource) FindSymbol(name string) (protoreflect.Descriptor, error) {
+	res, err := s.call(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_FileContainingSymbol{FileContainingSymbol: name},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.addFiles(res); err != nil {
+		return nil, err
+	}
+
+	files, err := s.registry()
+	if err != nil {
+		return nil, err
+	}
+	return files.FindDescriptorByName(protoreflect.FullName(name))
+}
+
+// addFiles records the files of a response and fetches their missing
+// dependencies.
+func (s *reflectionSource) addFiles(res *reflectionpb.ServerReflectionResponse) error {
+	for _, raw := range res.GetFileDescriptorResponse().GetFileDescriptorProto() {
+		fd := &descriptorpb.FileDescriptorProto{}
+		if err := proto.Unmarshal(raw, fd); err != nil {
+			return err
+		}
+		s.files[fd.GetName()] = fd
+	}
+
+	for _, fd := range s.files {
+		for _, dep := range fd.GetDependency() {
+			if _, ok := s.files[dep]; ok {
+				continue
+			}
+			res, err := s.call(&reflectionpb.ServerReflectionRequest{
+				MessageRequest: &reflectionpb.ServerReflectionRequest_FileByFilename{FileByFilename: dep},
+			})
+			if err != nil {
+				return err
+			}
+			// the map changed, so start over
+			return s.addFiles(res)
+		}
+	}
+	return nil
+}
+
+func (s *reflectionSource) registry() (*protoregistry.Files, error) {
+	set := &descriptorpb.FileDescriptorSet{}
+	for _, fd := range s.files {
+		set.File = append(set.File, fd)
+	}
+	return protodesc.NewFiles(set)
+}
@@ -0,0 +1,265 @@
+package libp2pgrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// ErrPeerGone is returned by the client dialer when the peer is known
+// to be gone: it disconnected and dialing it again failed. RPCs failing
+// because of it carry its message.
+var ErrPeerGone = errors.New("libp2pgrpc: peer is gone")
+
+type peerGoneError struct {
+	id peer.ID
+	// err is the error of the failed redial, if this is the one.
+	err error
+}
+
+func (e *peerGoneError) Error() string {
+	if e.err != nil {
+		return fmt.Sprintf("libp2pgrpc: peer %s is gone: %s", e.id, e.err)
+	}
+	return fmt.Sprintf("libp2pgrpc: peer %s is gone", e.id)
+}
+
+func (e *peerGoneError) Unwrap() error {
+	return e.err
+}
+
+func (e *peerGoneError) Is(target error) bool {
+	return target == ErrPeerGone
+}
+
+// Temporary reports the error as permanent, so dials made with
+// grpc.FailOnNonTempDialError give up right away.
+func (e *peerGoneError) Temporary() bool {
+	return false
+}
+
+// connWatcher follows the connectivity events of the client host, so
+// the client connections reconnect as soon as their peers are
+// reachable again instead of waiting out gRPC's backoff. It lives as
+// long as there are connections or resolvers to watch.
+type connWatcher struct {
+	host host.Host
+	sub  event.Subscription
+
+	mu    sync.Mutex
+	conns map[*grpc.ClientConn]func(peer.ID) bool
+	// disconnected holds the watched peers last seen disconnecting.
+	disconnected map[peer.ID]struct{}
+	// failed holds the watched peers whose last dial failed: the
+	// disconnect event and the failed dial may come in either order.
+	failed map[peer.ID]struct{}
+	// resolvers are the resolvers to reconnect on direct connections
+	// (see watchUpgrades).
+	resolvers map[upgradable]struct{}
+}
+
+// acquireWatcher returns the watcher of the client, starting one if
+// there is none. Callers register with it before releasing
+// c.watcherMu, then call releaseWatcher once they are done with it.
+func (c *Client) acquireWatcher() *connWatcher {
+	if c.watcher != nil {
+		return c.watcher
+	}
+
+	sub, err := c.host.EventBus().Subscribe([]interface{}{
+		new(event.EvtPeerConnectednessChanged),
+		new(event.EvtPeerIdentificationCompleted),
+	})
+	if err != nil {
+		log.Debugw("failed to subscribe to connectivity events", "err", err)
+		return nil
+	}
+
+	c.watcher = &connWatcher{
+		host:         c.host,
+		sub:          sub,
+		conns:        make(map[*grpc.ClientConn]func(peer.ID) bool),
+		disconnected: make(map[peer.ID]struct{}),
+		failed:       make(map[peer.ID]struct{}),
+		resolvers:    make(map[upgradable]struct{}),
+	}
+	go c.watcher.run()
+	return c.watcher
+}
+
+// releaseWatcher stops w once it has nothing left to watch. It must be
+// called with c.watcherMu held.
+func (c *Client) releaseWatcher(w *connWatcher) {
+	w.mu.Lock()
+	idle := len(w.conns) == 0 && len(w.resolvers) == 0
+	w.mu.Unlock()
+
+	if idle && c.watcher == w {
+		c.watcher = nil
+		w.close()
+	}
+}
+
+// currentWatcher returns the watcher of the client, if it has one.
+func (c *Client) currentWatcher() *connWatcher {
+	c.watcherMu.Lock()
+	defer c.watcherMu.Unlock()
+
+	return c.watcher
+}
+
+// watch makes conn reconnect as soon as one of the peers it wants
+// becomes reachable, until conn is shut down.
+func (c *Client) watch(conn *grpc.ClientConn, wants func(peer.ID) bool) {
+	c.watcherMu.Lock()
+	defer c.watcherMu.Unlock()
+
+	w := c.acquireWatcher()
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	w.conns[conn] = wants
+	w.mu.Unlock()
+
+	go func() {
+		ctx := context.Background()
+		for state := conn.GetState(); state != connectivity.Shutdown; state = conn.GetState() {
+			conn.WaitForStateChange(ctx, state)
+		}
+
+		c.watcherMu.Lock()
+		defer c.watcherMu.Unlock()
+		w.remove(conn)
+		c.releaseWatcher(w)
+	}()
+}
+
+// dialFailed records that dialing the peer failed. It returns a
+// peerGoneError wrapping err if the peer had disconnected, and err
+// otherwise. Refusals of the relay policy say nothing about the peer.
+func (c *Client) dialFailed(id peer.ID, err error) error {
+	var relayErr *RelayedConnError
+	if errors.As(err, &relayErr) {
+		return err
+	}
+	w := c.currentWatcher()
+	if w == nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.failed[id] = struct{}{}
+	if _, ok := w.disconnected[id]; !ok {
+		return err
+	}
+	return &peerGoneError{id: id, err: err}
+}
+
+func (w *connWatcher) run() {
+	for e := range w.sub.Out() {
+		switch e := e.(type) {
+		case event.EvtPeerConnectednessChanged:
+			if e.Connectedness == network.Connected {
+				w.reachable(e.Peer)
+			} else {
+				w.unreachable(e.Peer)
+			}
+		case event.EvtPeerIdentificationCompleted:
+			// identification completes for every new connection,
+			// the direct ones established by hole punching included.
+			w.reachable(e.Peer)
+			w.upgrade(e.Peer)
+		}
+	}
+}
+
+func (w *connWatcher) reachable(id peer.ID) {
+	w.mu.Lock()
+	delete(w.disconnected, id)
+	delete(w.failed, id)
+	conns := w.wantingLocked(id)
+	w.mu.Unlock()
+
+	for _, conn := range conns {
+		switch conn.GetState() {
+		case connectivity.Ready, connectivity.Shutdown:
+		default:
+			conn.ResetConnectBackoff()
+			conn.Connect()
+		}
+	}
+}
+
+func (w *connWatcher) unreachable(id peer.ID) {
+	w.mu.Lock()
+	if !w.wantedLocked(id) {
+		w.mu.Unlock()
+		return
+	}
+	w.disconnected[id] = struct{}{}
+	_, failed := w.failed[id]
+	conns := w.wantingLocked(id)
+	w.mu.Unlock()
+
+	// a redial failed before the event came in: have the connections
+	// waiting out their backoff fail with ErrPeerGone right away.
+	if failed {
+		for _, conn := range conns {
+			if conn.GetState() == connectivity.TransientFailure {
+				conn.ResetConnectBackoff()
+			}
+		}
+	}
+}
+
+func (w *connWatcher) remove(conn *grpc.ClientConn) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.conns, conn)
+	for id := range w.failed {
+		if !w.wantedLocked(id) {
+			delete(w.failed, id)
+		}
+	}
+	for id := range w.disconnected {
+		if !w.wantedLocked(id) {
+			delete(w.disconnected, id)
+		}
+	}
+}
+
+func (w *connWatcher) wantedLocked(id peer.ID) bool {
+	for _, wants := range w.conns {
+		if wants(id) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *connWatcher) wantingLocked(id peer.ID) []*grpc.ClientConn {
+	var conns []*grpc.ClientConn
+	for conn, wants := range w.conns {
+		if wants(id) {
+			conns = append(conns, conn)
+		}
+	}
+	return conns
+}
+
+func (w *connWatcher) close() {
+	w.sub.Close()
+}
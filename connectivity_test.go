@@ -0,0 +1,150 @@
+package libp2pgrpc_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/p2p/net/swarm"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
+	proto "github.com/drgomesp/go-libp2p-grpc/proto/v1"
+)
+
+// listenCloser is implemented by the swarm of libp2p hosts.
+type listenCloser interface {
+	ListenClose(addrs ...multiaddr.Multiaddr)
+}
+
+// slowBackoff makes gRPC wait far longer than the tests before it
+// retries a failed connection on its own.
+var slowBackoff = grpc.WithConnectParams(grpc.ConnectParams{
+	Backoff:           backoff.Config{BaseDelay: time.Minute, Multiplier: 1, MaxDelay: time.Minute},
+	MinConnectTimeout: time.Second,
+})
+
+func TestDialFollowsConnectivityEvents(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 2)
+	cliHost, srvHost := hosts[0], hosts[1]
+	serveReplicas(t, hosts[1:])
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	defer client.Close()
+
+	conn, err := client.Dial(ctx, srvHost.ID(), slowBackoff)
+	assert.NoError(t, err)
+	defer conn.Close()
+	node := proto.NewNodeServiceClient(conn)
+
+	_, err = node.Info(ctx, &proto.NodeInfoRequest{}, grpc.WaitForReady(true))
+	assert.NoError(t, err)
+
+	// the peer leaves, while its addresses stay in the peerstore; it
+	// forgets the client so that it doesn't dial it back
+	addrs := srvHost.Network().ListenAddresses()
+	srvHost.Network().(listenCloser).ListenClose(addrs...)
+	srvHost.Peerstore().ClearAddrs(cliHost.ID())
+	assert.NoError(t, srvHost.Network().ClosePeer(cliHost.ID()))
+	assert.NoError(t, cliHost.Network().ClosePeer(srvHost.ID()))
+	assert.NotEmpty(t, cliHost.Peerstore().Addrs(srvHost.ID()))
+
+	assert.Eventually(t, func() bool {
+		_, err := node.Info(ctx, &proto.NodeInfoRequest{})
+		return status.Code(err) == codes.Unavailable && strings.Contains(err.Error(), "is gone")
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// the peer comes back well before the gRPC backoff is over
+	assert.NoError(t, srvHost.Network().Listen(addrs...))
+	assert.NoError(t, cliHost.Connect(network.WithForceDirectDial(ctx, "back"), peer.AddrInfo{ID: srvHost.ID()}))
+
+	rpcCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, err = node.Info(rpcCtx, &proto.NodeInfoRequest{}, grpc.WaitForReady(true))
+	assert.NoError(t, err)
+}
+
+func TestDialRedialsGonePeer(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 2)
+	cliHost, srvHost := hosts[0], hosts[1]
+	serveReplicas(t, hosts[1:])
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	defer client.Close()
+
+	conn, err := client.Dial(ctx, srvHost.ID(), grpc.WithConnectParams(grpc.ConnectParams{
+		Backoff:           backoff.Config{BaseDelay: 100 * time.Millisecond, Multiplier: 1, MaxDelay: 100 * time.Millisecond},
+		MinConnectTimeout: time.Second,
+	}))
+	assert.NoError(t, err)
+	defer conn.Close()
+	node := proto.NewNodeServiceClient(conn)
+
+	_, err = node.Info(ctx, &proto.NodeInfoRequest{}, grpc.WaitForReady(true))
+	assert.NoError(t, err)
+
+	addrs := srvHost.Network().ListenAddresses()
+	srvHost.Network().(listenCloser).ListenClose(addrs...)
+	srvHost.Peerstore().ClearAddrs(cliHost.ID())
+	assert.NoError(t, srvHost.Network().ClosePeer(cliHost.ID()))
+	assert.NoError(t, cliHost.Network().ClosePeer(srvHost.ID()))
+
+	assert.Eventually(t, func() bool {
+		_, err := node.Info(ctx, &proto.NodeInfoRequest{})
+		return status.Code(err) == codes.Unavailable && strings.Contains(err.Error(), "is gone")
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// the peer comes back, and nothing else connects to it: the
+	// redials of gRPC reach it once the dial backoff of the host is over
+	assert.NoError(t, srvHost.Network().Listen(addrs...))
+	cliHost.Network().(*swarm.Swarm).Backoff().Clear(srvHost.ID())
+
+	rpcCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, err = node.Info(rpcCtx, &proto.NodeInfoRequest{}, grpc.WaitForReady(true))
+	assert.NoError(t, err)
+}
+
+func TestDialPeersFollowsConnectivityEvents(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 2)
+	cliHost, srvHost := hosts[0], hosts[1]
+	serveReplicas(t, hosts[1:])
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	defer client.Close()
+
+	conn, err := client.DialPeers(ctx, libp2pgrpc.StaticPeers(srvHost.ID()), slowBackoff)
+	assert.NoError(t, err)
+	defer conn.Close()
+	node := proto.NewNodeServiceClient(conn)
+
+	_, err = node.Info(ctx, &proto.NodeInfoRequest{}, grpc.WaitForReady(true))
+	assert.NoError(t, err)
+
+	cliHost.Peerstore().ClearAddrs(srvHost.ID())
+	assert.NoError(t, cliHost.Network().ClosePeer(srvHost.ID()))
+	assert.Eventually(t, func() bool {
+		_, err := node.Info(ctx, &proto.NodeInfoRequest{})
+		return status.Code(err) == codes.Unavailable
+	}, 2*time.Second, 10*time.Millisecond)
+
+	cliHost.Peerstore().AddAddrs(srvHost.ID(), srvHost.Addrs(), peerstore.PermanentAddrTTL)
+	assert.NoError(t, cliHost.Connect(ctx, peer.AddrInfo{ID: srvHost.ID()}))
+
+	rpcCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, err = node.Info(rpcCtx, &proto.NodeInfoRequest{}, grpc.WaitForReady(true))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,41 @@
+package libp2pgrpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatcherStopsWithLastConn(t *testing.T) {
+	ctx := context.Background()
+	h, err := libp2p.New(libp2p.NoListenAddrs)
+	assert.NoError(t, err)
+	defer h.Close()
+
+	// no Close: the watcher must not outlive the connections
+	c := NewClient(h, ProtocolID)
+
+	a, err := c.Dial(ctx, test.RandPeerIDFatal(t))
+	assert.NoError(t, err)
+	b, err := c.DialPeers(ctx, StaticPeers(test.RandPeerIDFatal(t)))
+	assert.NoError(t, err)
+	w := c.currentWatcher()
+	assert.NotNil(t, w)
+
+	a.Close()
+	time.Sleep(50 * time.Millisecond)
+	assert.Same(t, w, c.currentWatcher())
+
+	b.Close()
+	assert.Eventually(t, func() bool {
+		return c.currentWatcher() == nil
+	}, time.Second, 10*time.Millisecond)
+
+	// the subscription is closed
+	for range w.sub.Out() {
+	}
+}
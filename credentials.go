@@ -0,0 +1,76 @@
+package libp2pgrpc
+
+import (
+	"context"
+	"net"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/sec/insecure"
+	"google.golang.org/grpc/credentials"
+)
+
+var _ credentials.TransportCredentials = &transportCredentials{}
+
+// NewCredentials returns transport credentials that reuse the security
+// of the libp2p connection the gRPC connection runs over. Streams over
+// Noise, TLS or QUIC report credentials.PrivacyAndIntegrity, so
+// per-RPC credentials that require transport security work on top of
+// them. The remote peer is exposed as an *AuthInfo.
+//
+// Client.Dial and NewGrpcServer install these credentials by default.
+func NewCredentials() credentials.TransportCredentials {
+	return &transportCredentials{}
+}
+
+// transportCredentials do not touch the wire: libp2p has already
+// encrypted and authenticated the connection, so the handshakes only
+// describe the remote peer.
+type transportCredentials struct{}
+
+func (c *transportCredentials) ClientHandshake(_ context.Context, _ string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return rawConn, authInfoFromConn(rawConn), nil
+}
+
+func (c *transportCredentials) ServerHandshake(rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return rawConn, authInfoFromConn(rawConn), nil
+}
+
+func (c *transportCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: AuthType}
+}
+
+func (c *transportCredentials) Clone() credentials.TransportCredentials {
+	return &transportCredentials{}
+}
+
+func (c *transportCredentials) OverrideServerName(string) error {
+	return nil
+}
+
+func authInfoFromConn(conn net.Conn) credentials.AuthInfo {
+	switch c := conn.(type) {
+	case network.Stream:
+		return newAuthInfo(c)
+	case *localConn:
+		return c.info
+	}
+	return &AuthInfo{CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.NoSecurity}}
+}
+
+func securityLevel(conn network.Conn) credentials.SecurityLevel {
+	state := conn.ConnState()
+	switch {
+	case state.Security == insecure.ID:
+		return credentials.NoSecurity
+	case state.Security != "":
+		return credentials.PrivacyAndIntegrity
+	}
+
+	// these transports secure connections on their own and do not
+	// report a separate security protocol.
+	switch state.Transport {
+	case "quic", "quic-v1", "webtransport", "webrtc":
+		return credentials.PrivacyAndIntegrity
+	}
+	return credentials.NoSecurity
+}
@@ -0,0 +1,86 @@
+package libp2pgrpc_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
+	proto "github.com/drgomesp/go-libp2p-grpc/proto/v1"
+)
+
+type bearerToken string
+
+func (t bearerToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+func (bearerToken) RequireTransportSecurity() bool {
+	return true
+}
+
+type tokenCheckingService struct {
+	NodeInfoService
+}
+
+func (s *tokenCheckingService) Info(ctx context.Context, req *proto.NodeInfoRequest) (*proto.NodeInfoResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if got := md.Get("authorization"); len(got) != 1 || got[0] != "Bearer secret" {
+		return nil, status.Error(codes.Unauthenticated, "missing token")
+	}
+	return s.NodeInfoService.Info(ctx, req)
+}
+
+func TestCredentialsWithPerRPCCredentials(t *testing.T) {
+	ctx := context.Background()
+	srvHost, cliHost := newConnectedHosts(t)
+
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost)
+	assert.NoError(t, err)
+	defer srv.Stop()
+	proto.RegisterNodeServiceServer(srv, &tokenCheckingService{NodeInfoService{host: srvHost}})
+	go srv.Serve()
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	conn, err := client.Dial(ctx, srvHost.ID(), grpc.WithPerRPCCredentials(bearerToken("secret")))
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	res, err := proto.NewNodeServiceClient(conn).Info(ctx, &proto.NodeInfoRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, srvHost.ID().String(), res.GetId())
+}
+
+func TestCredentialsInsecureRejectsPerRPCCredentials(t *testing.T) {
+	ctx := context.Background()
+	srvHost, cliHost := newConnectedHosts(t)
+
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost)
+	assert.NoError(t, err)
+	defer srv.Stop()
+	proto.RegisterNodeServiceServer(srv, &tokenCheckingService{NodeInfoService{host: srvHost}})
+	go srv.Serve()
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	_, err = client.Dial(ctx, srvHost.ID(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithPerRPCCredentials(bearerToken("secret")),
+	)
+	assert.ErrorContains(t, err, "transport level security")
+}
+
+func TestCredentialsInfo(t *testing.T) {
+	creds := libp2pgrpc.NewCredentials()
+	assert.Equal(t, libp2pgrpc.AuthType, creds.Info().SecurityProtocol)
+	assert.Equal(t, creds.Info(), creds.Clone().Info())
+	assert.NoError(t, creds.OverrideServerName("ignored"))
+
+	var _ credentials.TransportCredentials = creds
+}
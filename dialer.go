@@ -3,29 +3,101 @@ package libp2pgrpc
 import (
 	"context"
 	"net"
+	"time"
 
 	gostream "github.com/libp2p/go-libp2p-gostream"
+	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
+// GetDialOption returns the context dialer that opens libp2p streams.
+// The dialed address must be a peer ID; the stream uses the protocol
+// attached by the resolver, or the client protocol otherwise.
 func (c *Client) GetDialOption(_ context.Context) grpc.DialOption {
+	return c.dialer(c.relayPolicy, nil)
+}
+
+// dialer returns the context dialer applying policy. The outcome of its
+// dials is recorded in errs, if set.
+func (c *Client) dialer(policy RelayPolicy, errs *dialErrors) grpc.DialOption {
 	return grpc.WithContextDialer(func(ctx context.Context, peerIdStr string) (net.Conn, error) {
 		peerID, err := peer.Decode(peerIdStr)
 		if err != nil {
 			return nil, err
 		}
 
-		conn, err := gostream.Dial(ctx, c.host, peerID, c.protocol)
+		proto := c.protocol
+		attrs := credentials.ClientHandshakeInfoFromContext(ctx).Attributes
+		if p, ok := protocolFromAttributes(attrs); ok {
+			proto = p
+		}
+
+		if s := c.localServer(peerID, proto); s != nil {
+			return s.local.dial(ctx, c.host, proto)
+		}
+
+		start := time.Now()
+		conn, err := gostream.Dial(relayContext(ctx, policy), c.host, peerID, proto)
+		stream, _ := conn.(network.Stream)
+		err = c.checkRelay(peerID, policy, stream, err)
+		errs.record(peerID, err)
+		conn = c.meterDial(conn, proto, time.Since(start), err)
 		if err != nil {
+			if ctx.Err() == nil {
+				err = c.dialFailed(peerID, err)
+			}
 			return nil, err
 		}
+		if stream != nil {
+			if err := stream.Scope().SetService(ResourceService); err != nil {
+				// a metered stream counts as closed once reset
+				conn.(network.Stream).Reset()
+				return nil, err
+			}
+			if streams, ok := attrs.Value(dialedStreamsAttrKey{}).(*dialedStreams); ok {
+				streams.set(peerID, stream)
+			}
+		}
 
 		return conn, nil
 	})
 }
 
+// DialOptions returns the options needed to dial libp2p targets (see
+// Scheme) with a plain grpc.Dial:
+//
+//	conn, err := grpc.Dial("libp2p:///12D3...", client.DialOptions(ctx)...)
+func (c *Client) DialOptions(ctx context.Context) []grpc.DialOption {
+	return c.dialOptions(c.relayPolicy)
+}
+
+func (c *Client) dialOptions(policy RelayPolicy) []grpc.DialOption {
+	errs := newDialErrors()
+	opts := append([]grpc.DialOption{
+		c.dialer(policy, errs),
+		grpc.WithResolvers(c.Resolver()),
+		grpc.WithTransportCredentials(NewCredentials()),
+	}, relayErrorInterceptors(errs)...)
+	return c.withStatsHandler(opts)
+}
+
+// Dial creates a gRPC client connection to the given peer. The libp2p
+// credentials from NewCredentials are installed first, so they can
+// still be overridden through dialOpts.
+//
+// The connection follows the connectivity events of the host: it
+// reconnects as soon as the peer is connected again, and fails fast
+// with ErrPeerGone once the peer is known to be gone. A RelayOption
+// among dialOpts overrides the relay policy of the Client.
 func (c *Client) Dial(ctx context.Context, peerID peer.ID, dialOpts ...grpc.DialOption) (*grpc.ClientConn, error) {
-	dialOpsPrepended := append([]grpc.DialOption{c.GetDialOption(ctx)}, dialOpts...)
-	return grpc.DialContext(ctx, peerID.String(), dialOpsPrepended...)
+	dialOpsPrepended := append(c.dialOptions(c.dialRelayPolicy(dialOpts)), dialOpts...)
+	conn, err := grpc.DialContext(ctx, Target(peerID), dialOpsPrepended...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.watch(conn, func(id peer.ID) bool { return id == peerID })
+	return conn, nil
 }
@@ -0,0 +1,139 @@
+package libp2pgrpc
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/discovery"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/core/routing"
+	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+	dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
+)
+
+// DefaultDiscoveryInterval is how often a PeerSource returned by
+// Client.DiscoverService looks for providers again.
+var DefaultDiscoveryInterval = time.Minute
+
+// ServiceNamespace returns the discovery namespace under which servers
+// listening on protocol p advertise the fully qualified gRPC service.
+func ServiceNamespace(p protocol.ID, service string) string {
+	return string(p) + "/" + service
+}
+
+// WithDiscovery makes the Server advertise every registered service
+// through a, for as long as it serves. See ServiceNamespace.
+func WithDiscovery(a discovery.Advertiser) ServerOption {
+	return ServerOption{apply: func(s *Server) {
+		s.advertisers = append(s.advertisers, a)
+	}}
+}
+
+// WithContentRouting makes the Server advertise every registered
+// service as a provider record through r, e.g. the libp2p DHT.
+func WithContentRouting(r routing.ContentRouting) ServerOption {
+	return WithDiscovery(drouting.NewRoutingDiscovery(r))
+}
+
+// advertise starts advertising all registered services on all
+// protocols until ctx is done.
+func (s *Server) advertise(ctx context.Context) {
+	for name := range s.grpc.GetServiceInfo() {
+		for _, p := range s.protocols {
+			for _, a := range s.advertisers {
+				dutil.Advertise(ctx, a, ServiceNamespace(p, name))
+			}
+		}
+	}
+}
+
+// WithDiscoveryInterval sets how often the sources returned by
+// DiscoverService look for providers again.
+func WithDiscoveryInterval(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.discoveryInterval = d
+	}
+}
+
+// DiscoverService returns a PeerSource with the peers that provide the
+// fully qualified gRPC service on the client protocol, as found
+// through d. Use drouting.NewRoutingDiscovery to look providers up
+// through a routing.ContentRouting such as the DHT. The result can be
+// passed to DialPeers:
+//
+//	conn, err := client.DialPeers(ctx, client.DiscoverService(d, "helloworld.Greeter"))
+func (c *Client) DiscoverService(d discovery.Discoverer, service string) PeerSource {
+	ns := ServiceNamespace(c.protocol, service)
+
+	return PeerSourceFunc(func(ctx context.Context) (<-chan []peer.ID, error) {
+		interval := c.discoveryInterval
+		if interval <= 0 {
+			interval = DefaultDiscoveryInterval
+		}
+
+		ch := make(chan []peer.ID)
+		go func() {
+			defer close(ch)
+
+			var last []peer.ID
+			for {
+				ids := c.findProviders(ctx, d, ns)
+				if len(ids) > 0 && !equalPeerIDs(ids, last) {
+					select {
+					case ch <- ids:
+						last = ids
+					case <-ctx.Done():
+						return
+					}
+				}
+
+				select {
+				case <-time.After(interval):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return ch, nil
+	})
+}
+
+// findProviders returns the sorted IDs of the providers of ns, adding
+// their addresses to the peerstore.
+func (c *Client) findProviders(ctx context.Context, d discovery.Discoverer, ns string) []peer.ID {
+	infos, err := dutil.FindPeers(ctx, d, ns)
+	if err != nil {
+		log.Debugw("failed to find providers", "namespace", ns, "err", err)
+		return nil
+	}
+
+	ids := make([]peer.ID, 0, len(infos))
+	seen := make(map[peer.ID]struct{}, len(infos))
+	for _, info := range infos {
+		if _, ok := seen[info.ID]; ok || info.ID == c.host.ID() {
+			continue
+		}
+		seen[info.ID] = struct{}{}
+		if len(info.Addrs) > 0 {
+			c.host.Peerstore().AddAddrs(info.ID, info.Addrs, peerstore.AddressTTL)
+		}
+		ids = append(ids, info.ID)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func equalPeerIDs(a, b []peer.ID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
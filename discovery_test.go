@@ -0,0 +1,82 @@
+package libp2pgrpc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/peer"
+	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/assert"
+
+	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
+	proto "github.com/drgomesp/go-libp2p-grpc/proto/v1"
+)
+
+func TestServiceNamespace(t *testing.T) {
+	assert.Equal(t,
+		"/libp2p/grpc/1.0.0/proto.v1.NodeService",
+		libp2pgrpc.ServiceNamespace(libp2pgrpc.ProtocolID, proto.NodeService_ServiceDesc.ServiceName),
+	)
+}
+
+func TestDiscoverServiceThroughDHT(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn, err := mocknet.FullMeshLinked(4)
+	assert.NoError(t, err)
+	defer mn.Close()
+	hosts := mn.Hosts()
+
+	dhts := make([]*dht.IpfsDHT, len(hosts))
+	for i, h := range hosts {
+		dhts[i], err = dht.New(ctx, h, dht.Mode(dht.ModeServer))
+		assert.NoError(t, err)
+		defer dhts[i].Close()
+	}
+	assert.NoError(t, mn.ConnectAllButSelf())
+	assert.Eventually(t, func() bool {
+		for _, d := range dhts {
+			if d.RoutingTable().Size() == 0 {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 50*time.Millisecond)
+
+	cliHost, cliDHT := hosts[0], dhts[0]
+	providers := hosts[1:3]
+	for i, h := range providers {
+		srv, err := libp2pgrpc.NewGrpcServer(ctx, h, libp2pgrpc.WithContentRouting(dhts[i+1]))
+		assert.NoError(t, err)
+		defer srv.Stop()
+		serveNodeInfo(t, srv, h)
+	}
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID, libp2pgrpc.WithDiscoveryInterval(100*time.Millisecond))
+	src := client.DiscoverService(drouting.NewRoutingDiscovery(cliDHT), proto.NodeService_ServiceDesc.ServiceName)
+
+	updates, err := src.Peers(ctx)
+	assert.NoError(t, err)
+
+	want := map[peer.ID]bool{providers[0].ID(): true, providers[1].ID(): true}
+	for found := false; !found; {
+		select {
+		case ids := <-updates:
+			found = len(ids) == len(want) && want[ids[0]] && want[ids[1]]
+		case <-time.After(10 * time.Second):
+			t.Fatal("providers were not discovered")
+		}
+	}
+
+	conn, err := client.DialPeers(ctx, src)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	assert.Eventually(t, func() bool {
+		return len(collectIDs(conn, 4)) == 2
+	}, 10*time.Second, 50*time.Millisecond)
+}
@@ -12,8 +12,6 @@ import (
 	"github.com/libp2p/go-libp2p/core/peerstore"
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/multiformats/go-multiaddr"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 
 	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
 	proto "github.com/drgomesp/go-libp2p-grpc/proto/v1"
@@ -81,7 +79,7 @@ func main() {
 
 		// h2 will act as the grpc client here, dialing the h1 server
 		client := libp2pgrpc.NewClient(h2, libp2pgrpc.ProtocolID, libp2pgrpc.WithServer(srv))
-		conn, err := client.Dial(ctx, h1.ID(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		conn, err := client.Dial(ctx, h1.ID())
 		check(err)
 
 		mux := runtime.NewServeMux()
@@ -105,7 +103,7 @@ func main() {
 
 		// h1 will act as the grpc client here, dialing the h2 server
 		client := libp2pgrpc.NewClient(h1, libp2pgrpc.ProtocolID, libp2pgrpc.WithServer(srv))
-		conn, err := client.Dial(ctx, h2.ID(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		conn, err := client.Dial(ctx, h2.ID())
 		check(err)
 
 		mux := runtime.NewServeMux()
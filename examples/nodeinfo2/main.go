@@ -18,7 +18,6 @@ import (
 	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/multiformats/go-multiaddr"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 
 	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
 	proto "github.com/drgomesp/go-libp2p-grpc/proto/v1"
@@ -122,7 +121,7 @@ func main() {
 
 		// ha will act as the grpc client here, dialing the h2 server
 		client := libp2pgrpc.NewClient(ha, libp2pgrpc.ProtocolID)
-		opts := []grpc.DialOption{grpc.WithBlock(), grpc.WithTransportCredentials(insecure.NewCredentials())}
+		opts := []grpc.DialOption{grpc.WithBlock()}
 		conn, err := client.Dial(ctx, info.ID, opts...)
 		check(err)
 
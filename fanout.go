@@ -0,0 +1,158 @@
+package libp2pgrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"google.golang.org/grpc"
+)
+
+// FanOutCall issues a unary RPC on conn, typically through a generated
+// client:
+//
+//	func(ctx context.Context, conn grpc.ClientConnInterface) (interface{}, error) {
+//		return pb.NewNodeServiceClient(conn).Info(ctx, &pb.NodeInfoRequest{})
+//	}
+type FanOutCall func(ctx context.Context, conn grpc.ClientConnInterface) (interface{}, error)
+
+// FanOutResult is the outcome of a FanOutCall on one peer.
+type FanOutResult struct {
+	Peer     peer.ID
+	Response interface{}
+	Err      error
+}
+
+// FanOutOption configures FanOut.
+type FanOutOption func(*fanOutConfig)
+
+type fanOutConfig struct {
+	peerTimeout time.Duration
+	quorum      int
+}
+
+// WithPeerTimeout gives the call on every peer its own deadline, on
+// top of the one of the FanOut context.
+func WithPeerTimeout(d time.Duration) FanOutOption {
+	return func(c *fanOutConfig) {
+		c.peerTimeout = d
+	}
+}
+
+// ErrQuorumUnreachable is the error of the last result of a FanOut with
+// WithQuorum when too many peers failed for the quorum to be reached.
+// FanOut returns it right away if the quorum is larger than the peers.
+var ErrQuorumUnreachable = errors.New("libp2pgrpc: quorum unreachable")
+
+// WithQuorum makes FanOut stop once n peers succeeded: the calls still
+// running are cancelled and the results channel is closed right after
+// the n-th success. Once too many peers failed for n to succeed, FanOut
+// stops the same way after a last result, with no peer and
+// ErrQuorumUnreachable as its error.
+func WithQuorum(n int) FanOutOption {
+	return func(c *fanOutConfig) {
+		c.quorum = n
+	}
+}
+
+// FanOut calls every peer of src concurrently, over the connections
+// pooled by Conn, and sends each outcome on the returned channel as it
+// comes in. The peers are the first set src sends; FanOut waits for
+// it until ctx is done. The channel is closed once every peer answered,
+// or the quorum of WithQuorum is reached or out of reach. It is
+// buffered for every result, so callers may stop reading at any time.
+func (c *Client) FanOut(ctx context.Context, src PeerSource, call FanOutCall, opts ...FanOutOption) (<-chan FanOutResult, error) {
+	var cfg fanOutConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	ids, err := firstPeers(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.quorum > len(ids) {
+		return nil, fmt.Errorf("%w: quorum of %d with %d peers", ErrQuorumUnreachable, cfg.quorum, len(ids))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	outcomes := make(chan FanOutResult, len(ids))
+	for _, id := range ids {
+		go func(id peer.ID) {
+			outcomes <- c.fanOutCall(ctx, id, call, cfg.peerTimeout)
+		}(id)
+	}
+
+	// one more for the ErrQuorumUnreachable result
+	results := make(chan FanOutResult, len(ids)+1)
+	go func() {
+		defer cancel()
+		defer close(results)
+
+		successes, failures := 0, 0
+		for range ids {
+			res := <-outcomes
+			results <- res
+			if cfg.quorum <= 0 {
+				continue
+			}
+			if res.Err != nil {
+				if failures++; failures > len(ids)-cfg.quorum {
+					results <- FanOutResult{Err: ErrQuorumUnreachable}
+					return
+				}
+				continue
+			}
+			if successes++; successes >= cfg.quorum {
+				return
+			}
+		}
+	}()
+	return results, nil
+}
+
+func (c *Client) fanOutCall(ctx context.Context, id peer.ID, call FanOutCall, timeout time.Duration) FanOutResult {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	conn, err := c.Conn(ctx, id)
+	if err != nil {
+		return FanOutResult{Peer: id, Err: err}
+	}
+	res, err := call(ctx, conn)
+	return FanOutResult{Peer: id, Response: res, Err: err}
+}
+
+// firstPeers returns the first set of peers sent by src, without the
+// duplicates.
+func firstPeers(ctx context.Context, src PeerSource) ([]peer.ID, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	updates, err := src.Peers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []peer.ID
+	select {
+	case ids = <-updates:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	seen := make(map[peer.ID]struct{}, len(ids))
+	unique := make([]peer.ID, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			unique = append(unique, id)
+		}
+	}
+	return unique, nil
+}
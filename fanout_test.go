@@ -0,0 +1,220 @@
+package libp2pgrpc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/status"
+
+	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
+	proto "github.com/drgomesp/go-libp2p-grpc/proto/v1"
+)
+
+func callInfoFanOut(ctx context.Context, conn grpc.ClientConnInterface) (interface{}, error) {
+	return proto.NewNodeServiceClient(conn).Info(ctx, &proto.NodeInfoRequest{})
+}
+
+func callUnaryFanOut(ctx context.Context, conn grpc.ClientConnInterface) (interface{}, error) {
+	return testpb.NewTestServiceClient(conn).UnaryCall(ctx, &testpb.SimpleRequest{}, grpc.WaitForReady(true))
+}
+
+// serveFanOut serves the test service on h, with UnaryCall blocking
+// until the call is cancelled if block is set.
+func serveFanOut(t *testing.T, h host.Host, block bool) *blockingService {
+	srv, err := libp2pgrpc.NewGrpcServer(context.Background(), h)
+	assert.NoError(t, err)
+	t.Cleanup(srv.Stop)
+
+	svc := &blockingService{started: make(chan struct{}, 16), release: make(chan struct{})}
+	if !block {
+		close(svc.release)
+	}
+	testpb.RegisterTestServiceServer(srv, svc)
+	serveNodeInfo(t, srv, h)
+	return svc
+}
+
+func collectFanOut(t *testing.T, results <-chan libp2pgrpc.FanOutResult) map[peer.ID]libp2pgrpc.FanOutResult {
+	byPeer := make(map[peer.ID]libp2pgrpc.FanOutResult)
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case res, ok := <-results:
+			if !ok {
+				return byPeer
+			}
+			byPeer[res.Peer] = res
+		case <-timeout:
+			t.Fatal("fan-out results not closed")
+		}
+	}
+}
+
+func TestFanOut(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 4)
+	cliHost, servers := hosts[0], hosts[1:]
+	for _, h := range servers {
+		serveFanOut(t, h, false)
+	}
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	defer client.Close()
+
+	src := libp2pgrpc.StaticPeers(servers[0].ID(), servers[1].ID(), servers[2].ID(), servers[0].ID())
+	results, err := client.FanOut(ctx, src, callInfoFanOut)
+	assert.NoError(t, err)
+
+	byPeer := collectFanOut(t, results)
+	assert.Len(t, byPeer, 3)
+	for _, h := range servers {
+		res := byPeer[h.ID()]
+		if assert.NoError(t, res.Err) {
+			assert.Equal(t, h.ID().String(), res.Response.(*proto.NodeInfoResponse).GetId())
+		}
+	}
+}
+
+func TestFanOutPeerTimeout(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 4)
+	cliHost, fast, slow, down := hosts[0], hosts[1], hosts[2], hosts[3]
+	serveFanOut(t, fast, false)
+	serveFanOut(t, slow, true)
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	defer client.Close()
+
+	start := time.Now()
+	src := libp2pgrpc.StaticPeers(fast.ID(), slow.ID(), down.ID())
+	results, err := client.FanOut(ctx, src, callUnaryFanOut, libp2pgrpc.WithPeerTimeout(500*time.Millisecond))
+	assert.NoError(t, err)
+
+	byPeer := collectFanOut(t, results)
+	assert.Len(t, byPeer, 3)
+	assert.NoError(t, byPeer[fast.ID()].Err)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(byPeer[slow.ID()].Err))
+	assert.Error(t, byPeer[down.ID()].Err)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestFanOutQuorum(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 4)
+	cliHost, servers := hosts[0], hosts[1:]
+	serveFanOut(t, servers[0], false)
+	serveFanOut(t, servers[1], false)
+	serveFanOut(t, servers[2], true)
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	defer client.Close()
+
+	src := libp2pgrpc.StaticPeers(servers[0].ID(), servers[1].ID(), servers[2].ID())
+	results, err := client.FanOut(ctx, src, callUnaryFanOut, libp2pgrpc.WithQuorum(2))
+	assert.NoError(t, err)
+
+	byPeer := collectFanOut(t, results)
+	assert.Len(t, byPeer, 2)
+	assert.NotContains(t, byPeer, servers[2].ID())
+	for _, res := range byPeer {
+		assert.NoError(t, res.Err)
+	}
+}
+
+func TestFanOutQuorumUnreachable(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 4)
+	cliHost, fast, slow, down := hosts[0], hosts[1], hosts[2], hosts[3]
+	serveFanOut(t, fast, false)
+	serveFanOut(t, slow, true)
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	defer client.Close()
+
+	src := libp2pgrpc.StaticPeers(fast.ID(), slow.ID(), down.ID())
+	_, err := client.FanOut(ctx, src, callUnaryFanOut, libp2pgrpc.WithQuorum(4))
+	assert.ErrorIs(t, err, libp2pgrpc.ErrQuorumUnreachable)
+
+	// the down peer makes a quorum of every peer unreachable, without
+	// waiting for the slow one
+	failFast := func(ctx context.Context, conn grpc.ClientConnInterface) (interface{}, error) {
+		return testpb.NewTestServiceClient(conn).UnaryCall(ctx, &testpb.SimpleRequest{})
+	}
+	results, err := client.FanOut(ctx, src, failFast, libp2pgrpc.WithQuorum(3))
+	assert.NoError(t, err)
+
+	var last libp2pgrpc.FanOutResult
+	byPeer := make(map[peer.ID]libp2pgrpc.FanOutResult)
+	for res := range results {
+		byPeer[res.Peer] = res
+		last = res
+	}
+	assert.ErrorIs(t, last.Err, libp2pgrpc.ErrQuorumUnreachable)
+	assert.Empty(t, last.Peer)
+	assert.Error(t, byPeer[down.ID()].Err)
+	assert.NotContains(t, byPeer, slow.ID())
+}
+
+func TestFanOutTopicPeers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hosts := newHosts(t, 3)
+	cliHost, servers := hosts[0], hosts[1:]
+
+	var (
+		topics []*pubsub.Topic
+		subs   []*pubsub.Subscription
+	)
+	for _, h := range hosts {
+		ps, err := pubsub.NewGossipSub(ctx, h)
+		assert.NoError(t, err)
+		topic, err := ps.Join("cluster")
+		assert.NoError(t, err)
+		sub, err := topic.Subscribe()
+		assert.NoError(t, err)
+		topics = append(topics, topic)
+		subs = append(subs, sub)
+	}
+	for _, h := range servers {
+		serveFanOut(t, h, false)
+		assert.NoError(t, cliHost.Connect(ctx, peer.AddrInfo{ID: h.ID(), Addrs: h.Addrs()}))
+	}
+	assert.Eventually(t, func() bool {
+		return len(topics[0].ListPeers()) == len(servers)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	defer client.Close()
+
+	src := libp2pgrpc.TopicPeers(topics[0])
+	results, err := client.FanOut(ctx, src, callInfoFanOut)
+	assert.NoError(t, err)
+
+	byPeer := collectFanOut(t, results)
+	assert.Len(t, byPeer, len(servers))
+	for _, h := range servers {
+		assert.NoError(t, byPeer[h.ID()].Err)
+	}
+
+	// a peer leaving the topic is reported
+	updates, err := src.Peers(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, <-updates, len(servers))
+
+	subs[1].Cancel()
+	select {
+	case ids := <-updates:
+		assert.Equal(t, []peer.ID{servers[1].ID()}, ids)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no update after a peer left")
+	}
+}
@@ -0,0 +1,271 @@
+package libp2pgrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"google.golang.org/grpc"
+)
+
+// DefaultGatewayPathPrefix is the path prefix of the default gateway
+// router: requests to /peers/<peerID>/v1/... are forwarded to the peer
+// as /v1/....
+const DefaultGatewayPathPrefix = "/peers"
+
+// RegisterHandlerFunc registers the gRPC-Gateway handlers of a service
+// on a mux, e.g. proto.RegisterNodeServiceHandler.
+type RegisterHandlerFunc func(ctx context.Context, mux *runtime.ServeMux, conn *grpc.ClientConn) error
+
+// PeerRouter picks the peer an HTTP request is meant for. It returns
+// the request to hand to that peer's gateway mux, which may differ
+// from r, e.g. by having a routing prefix stripped.
+type PeerRouter func(r *http.Request) (peer.ID, *http.Request, error)
+
+// PathRouter routes requests of the form <prefix>/<peerID>/<path> to
+// the given peer, forwarding them as /<path>.
+func PathRouter(prefix string) PeerRouter {
+	prefix = strings.TrimSuffix(prefix, "/") + "/"
+
+	return func(r *http.Request) (peer.ID, *http.Request, error) {
+		rest := strings.TrimPrefix(r.URL.Path, prefix)
+		if rest == r.URL.Path {
+			return "", nil, fmt.Errorf("path must start with %s<peerID>", prefix)
+		}
+
+		idStr, path, _ := strings.Cut(rest, "/")
+		id, err := peer.Decode(idStr)
+		if err != nil {
+			return "", nil, err
+		}
+
+		fwd := r.Clone(r.Context())
+		fwd.URL.Path = "/" + path
+		fwd.URL.RawPath = ""
+		return id, fwd, nil
+	}
+}
+
+// HeaderRouter routes requests to the peer named in the given header.
+func HeaderRouter(header string) PeerRouter {
+	return func(r *http.Request) (peer.ID, *http.Request, error) {
+		v := r.Header.Get(header)
+		if v == "" {
+			return "", nil, fmt.Errorf("missing %s header", header)
+		}
+		id, err := peer.Decode(v)
+		if err != nil {
+			return "", nil, err
+		}
+		return id, r, nil
+	}
+}
+
+// GatewayOption allows for functional setting of options on a Gateway.
+type GatewayOption func(*Gateway)
+
+// WithPeerRouter sets how the Gateway picks the peer of a request. The
+// default is PathRouter(DefaultGatewayPathPrefix).
+func WithPeerRouter(router PeerRouter) GatewayOption {
+	return func(g *Gateway) {
+		g.router = router
+	}
+}
+
+// WithGatewayMaxPeers caps the number of peers the Gateway keeps a
+// connection to. The least recently used idle peer is evicted first.
+func WithGatewayMaxPeers(n int) GatewayOption {
+	return func(g *Gateway) {
+		g.maxPeers = n
+	}
+}
+
+// WithGatewayIdleTimeout closes the connection to a peer that has not
+// served a request for d.
+func WithGatewayIdleTimeout(d time.Duration) GatewayOption {
+	return func(g *Gateway) {
+		g.idleTimeout = d
+	}
+}
+
+// WithServeMuxOptions sets the options of the per-peer gateway muxes.
+func WithServeMuxOptions(opts ...runtime.ServeMuxOption) GatewayOption {
+	return func(g *Gateway) {
+		g.muxOpts = append(g.muxOpts, opts...)
+	}
+}
+
+// WithGatewayDialOptions sets extra options for dialing peers.
+func WithGatewayDialOptions(opts ...grpc.DialOption) GatewayOption {
+	return func(g *Gateway) {
+		g.dialOpts = append(g.dialOpts, opts...)
+	}
+}
+
+var _ http.Handler = &Gateway{}
+
+// Gateway serves gRPC-Gateway HTTP requests for any peer reachable by
+// a Client. It dials a peer the first time a request is routed to it
+// and caches the connection, along with a mux holding the registered
+// handlers, until the peer is evicted.
+type Gateway struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	client   *Client
+	handlers []RegisterHandlerFunc
+
+	router      PeerRouter
+	maxPeers    int
+	idleTimeout time.Duration
+	muxOpts     []runtime.ServeMuxOption
+	dialOpts    []grpc.DialOption
+
+	cache *connCache
+}
+
+// gatewayEntry is the cache entry of a peer.
+type gatewayEntry struct {
+	*cacheEntry
+	id peer.ID
+	// ready is closed once the peer is dialed, setting either mux and
+	// conn or err.
+	ready chan struct{}
+	mux   *runtime.ServeMux
+	err   error
+}
+
+// NewGateway creates a Gateway that forwards requests through c to the
+// services whose handlers are registered by handlers. Cancelling ctx
+// closes the gateway.
+func NewGateway(ctx context.Context, c *Client, handlers []RegisterHandlerFunc, opts ...GatewayOption) *Gateway {
+	g := &Gateway{
+		client:   c,
+		handlers: handlers,
+		router:   PathRouter(DefaultGatewayPathPrefix),
+	}
+	g.ctx, g.cancel = context.WithCancel(ctx)
+
+	for _, opt := range opts {
+		opt(g)
+	}
+
+	g.cache = newConnCache(g.maxPeers, g.idleTimeout)
+	go func() {
+		<-g.ctx.Done()
+		g.Close()
+	}()
+
+	return g
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, fwd, err := g.router(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entry, err := g.acquire(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer g.release(entry)
+
+	entry.mux.ServeHTTP(w, fwd)
+}
+
+// Close closes every cached connection.
+func (g *Gateway) Close() error {
+	g.cancel()
+	g.cache.close()
+	return nil
+}
+
+var errGatewayClosed = errors.New("libp2pgrpc: gateway closed")
+
+// acquire returns the entry of id, dialing the peer first if it has
+// none. The dial runs outside of g.mu: concurrent requests for the
+// same peer wait on the entry being dialed, the others go on.
+func (g *Gateway) acquire(id peer.ID) (*gatewayEntry, error) {
+	g.cache.mu.Lock()
+	if g.cache.closed {
+		g.cache.mu.Unlock()
+		return nil, errGatewayClosed
+	}
+
+	var entry *gatewayEntry
+	cached, ok := g.cache.entries[id]
+	if ok {
+		entry = cached.value.(*gatewayEntry)
+	} else {
+		entry = &gatewayEntry{id: id, ready: make(chan struct{})}
+		entry.cacheEntry = g.cache.addLocked(id, entry)
+	}
+
+	entry.active++
+	g.cache.touchLocked(entry.cacheEntry)
+	g.cache.mu.Unlock()
+
+	if !ok {
+		g.connect(entry)
+	}
+	<-entry.ready
+
+	if entry.err != nil {
+		g.release(entry)
+		return nil, entry.err
+	}
+	return entry, nil
+}
+
+func (g *Gateway) release(entry *gatewayEntry) {
+	g.cache.end(entry.cacheEntry)
+}
+
+// connect dials the peer of a new entry and marks it ready. A failed
+// entry is dropped, so that the next request dials again.
+func (g *Gateway) connect(entry *gatewayEntry) {
+	mux, conn, err := g.dial(entry.id)
+
+	g.cache.mu.Lock()
+	defer g.cache.mu.Unlock()
+	defer close(entry.ready)
+
+	if g.cache.entries[entry.id] != entry.cacheEntry {
+		// removed by Close while dialing
+		if conn != nil {
+			conn.Close()
+		}
+		entry.err = errGatewayClosed
+		return
+	}
+	if err != nil {
+		entry.err = err
+		g.cache.removeLocked(entry.cacheEntry)
+		return
+	}
+	entry.mux, entry.conn = mux, conn
+}
+
+func (g *Gateway) dial(id peer.ID) (*runtime.ServeMux, *grpc.ClientConn, error) {
+	conn, err := g.client.Dial(g.ctx, id, g.dialOpts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mux := runtime.NewServeMux(g.muxOpts...)
+	for _, register := range g.handlers {
+		if err := register(g.ctx, mux, conn); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+
+	return mux, conn, nil
+}
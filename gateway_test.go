@@ -0,0 +1,103 @@
+package libp2pgrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
+	proto "github.com/drgomesp/go-libp2p-grpc/proto/v1"
+)
+
+func getNodeInfo(t *testing.T, req *http.Request) (int, *proto.NodeInfoResponse) {
+	res, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		return 0, nil
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	if res.StatusCode != http.StatusOK {
+		return res.StatusCode, nil
+	}
+
+	var info *proto.NodeInfoResponse
+	assert.NoError(t, json.Unmarshal(data, &info))
+	return res.StatusCode, info
+}
+
+func TestGatewayPathRouting(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 3)
+	edgeHost, nodes := hosts[0], hosts[1:]
+	serveReplicas(t, nodes)
+
+	client := libp2pgrpc.NewClient(edgeHost, libp2pgrpc.ProtocolID)
+	gw := libp2pgrpc.NewGateway(ctx, client, []libp2pgrpc.RegisterHandlerFunc{proto.RegisterNodeServiceHandler})
+	defer gw.Close()
+
+	edge := httptest.NewServer(gw)
+	defer edge.Close()
+
+	for _, node := range nodes {
+		req, _ := http.NewRequest(http.MethodGet, edge.URL+"/peers/"+node.ID().String()+"/v1/node/info", nil)
+		code, info := getNodeInfo(t, req)
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, node.ID().String(), info.GetId())
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, edge.URL+"/peers/not-a-peer/v1/node/info", nil)
+	code, _ := getNodeInfo(t, req)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	req, _ = http.NewRequest(http.MethodGet, edge.URL+"/v1/node/info", nil)
+	code, _ = getNodeInfo(t, req)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestGatewayHeaderRouting(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 2)
+	edgeHost, node := hosts[0], hosts[1]
+	serveReplicas(t, hosts[1:])
+
+	client := libp2pgrpc.NewClient(edgeHost, libp2pgrpc.ProtocolID)
+	gw := libp2pgrpc.NewGateway(ctx, client,
+		[]libp2pgrpc.RegisterHandlerFunc{proto.RegisterNodeServiceHandler},
+		libp2pgrpc.WithPeerRouter(libp2pgrpc.HeaderRouter("X-Libp2p-Peer")),
+	)
+	defer gw.Close()
+
+	edge := httptest.NewServer(gw)
+	defer edge.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, edge.URL+"/v1/node/info", nil)
+	req.Header.Set("X-Libp2p-Peer", node.ID().String())
+	code, info := getNodeInfo(t, req)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, node.ID().String(), info.GetId())
+}
+
+func TestGatewayUnreachablePeer(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 2)
+	edgeHost, node := hosts[0], hosts[1]
+
+	client := libp2pgrpc.NewClient(edgeHost, libp2pgrpc.ProtocolID)
+	gw := libp2pgrpc.NewGateway(ctx, client, []libp2pgrpc.RegisterHandlerFunc{proto.RegisterNodeServiceHandler})
+	defer gw.Close()
+
+	edge := httptest.NewServer(gw)
+	defer edge.Close()
+
+	// the peer runs no gRPC server, so the gateway reports the failure
+	req, _ := http.NewRequest(http.MethodGet, edge.URL+"/peers/"+node.ID().String()+"/v1/node/info", nil)
+	code, _ := getNodeInfo(t, req)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+}
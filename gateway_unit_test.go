@@ -0,0 +1,133 @@
+package libp2pgrpc
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+)
+
+func newTestGateway(t *testing.T, opts ...GatewayOption) *Gateway {
+	h, err := libp2p.New(libp2p.NoListenAddrs)
+	assert.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+
+	g := NewGateway(context.Background(), NewClient(h, ProtocolID), nil, opts...)
+	t.Cleanup(func() { g.Close() })
+	return g
+}
+
+func cachedPeers(g *Gateway) []peer.ID {
+	g.cache.mu.Lock()
+	defer g.cache.mu.Unlock()
+
+	ids := make([]peer.ID, 0, len(g.cache.entries))
+	for e := g.cache.lru.Front(); e != nil; e = e.Next() {
+		ids = append(ids, e.Value.(*cacheEntry).key.(peer.ID))
+	}
+	return ids
+}
+
+func TestGatewayEvictsLeastRecentlyUsed(t *testing.T) {
+	g := newTestGateway(t, WithGatewayMaxPeers(2))
+	a, b, c := test.RandPeerIDFatal(t), test.RandPeerIDFatal(t), test.RandPeerIDFatal(t)
+
+	for _, id := range []peer.ID{a, b, a} {
+		entry, err := g.acquire(id)
+		assert.NoError(t, err)
+		g.release(entry)
+	}
+	assert.Equal(t, []peer.ID{a, b}, cachedPeers(g))
+
+	entry, err := g.acquire(c)
+	assert.NoError(t, err)
+	g.release(entry)
+	assert.Equal(t, []peer.ID{c, a}, cachedPeers(g))
+}
+
+func TestGatewayKeepsActivePeers(t *testing.T) {
+	g := newTestGateway(t, WithGatewayMaxPeers(1))
+	a, b := test.RandPeerIDFatal(t), test.RandPeerIDFatal(t)
+
+	entryA, err := g.acquire(a)
+	assert.NoError(t, err)
+	entryB, err := g.acquire(b)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []peer.ID{a, b}, cachedPeers(g))
+
+	g.release(entryA)
+	g.release(entryB)
+	entryB, err = g.acquire(b)
+	assert.NoError(t, err)
+	g.release(entryB)
+	assert.Equal(t, []peer.ID{b}, cachedPeers(g))
+}
+
+func TestGatewayEvictsIdlePeers(t *testing.T) {
+	g := newTestGateway(t, WithGatewayIdleTimeout(50*time.Millisecond))
+
+	entry, err := g.acquire(test.RandPeerIDFatal(t))
+	assert.NoError(t, err)
+	g.release(entry)
+
+	assert.Eventually(t, func() bool {
+		return len(cachedPeers(g)) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestGatewayClosed(t *testing.T) {
+	g := newTestGateway(t)
+	g.Close()
+
+	_, err := g.acquire(test.RandPeerIDFatal(t))
+	assert.Equal(t, errGatewayClosed, err)
+}
+
+func TestGatewayDialsOutsideLock(t *testing.T) {
+	blocked := make(chan struct{})
+	unblock := make(chan struct{})
+	var calls int32
+	register := func(ctx context.Context, mux *runtime.ServeMux, conn *grpc.ClientConn) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(blocked)
+			<-unblock
+		}
+		return nil
+	}
+
+	h, err := libp2p.New(libp2p.NoListenAddrs)
+	assert.NoError(t, err)
+	t.Cleanup(func() { h.Close() })
+	g := NewGateway(context.Background(), NewClient(h, ProtocolID), []RegisterHandlerFunc{register})
+	t.Cleanup(func() { g.Close() })
+
+	a, b := test.RandPeerIDFatal(t), test.RandPeerIDFatal(t)
+	done := make(chan *gatewayEntry, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			entry, err := g.acquire(a)
+			assert.NoError(t, err)
+			done <- entry
+		}()
+	}
+	<-blocked
+
+	// another peer is served while a is still registering
+	entry, err := g.acquire(b)
+	assert.NoError(t, err)
+	g.release(entry)
+
+	close(unblock)
+	first, second := <-done, <-done
+	assert.Same(t, first, second)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	g.release(first)
+	g.release(second)
+}
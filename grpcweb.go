@@ -0,0 +1,456 @@
+package libp2pgrpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// GrpcWebProtocolID is the protocol ID gRPC-Web requests are served on
+// by default.
+const GrpcWebProtocolID protocol.ID = "/libp2p/grpc-web/1.0.0"
+
+const (
+	grpcWebContentType     = "application/grpc-web"
+	grpcWebTextContentType = "application/grpc-web-text"
+
+	// grpcWebTrailerFlag marks the frame carrying the trailers.
+	grpcWebTrailerFlag byte = 0x80
+	// maxGrpcWebFrameSize bounds the size of a single gRPC-Web frame
+	// received by a client.
+	maxGrpcWebFrameSize = 64 << 20
+)
+
+// WithGrpcWeb makes the Server also accept the gRPC-Web wire format,
+// which browser peers can speak over WebTransport or WebRTC, on the
+// given protocol IDs, or on GrpcWebProtocolID if none are given.
+// gRPC-Web requests reach the same registered services and
+// interceptors, and PeerFromContext reports the browser peer.
+func WithGrpcWeb(ps ...protocol.ID) ServerOption {
+	if len(ps) == 0 {
+		ps = []protocol.ID{GrpcWebProtocolID}
+	}
+	return ServerOption{apply: func(s *Server) {
+		s.grpcWeb = append([]protocol.ID(nil), ps...)
+	}}
+}
+
+// serveGrpcWeb starts accepting gRPC-Web requests. The returned
+// function stops it.
+func (s *Server) serveGrpcWeb() (func(), error) {
+	listeners := make([]net.Listener, 0, len(s.grpcWeb))
+	for _, p := range s.grpcWeb {
+		listener, err := _gostream_Listen(s.host, p)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, s.meterListener(s.limitListener(listener)))
+	}
+
+	h := &grpcWebHandler{conns: newStreamConns(s, nil)}
+	httpServer := &http.Server{
+		Handler:     h,
+		ConnContext: h.conns.connContext,
+		ConnState:   h.conns.connState,
+	}
+	go func() {
+		if err := httpServer.Serve(newMultiListener(listeners...)); err != nil && err != http.ErrServerClosed {
+			log.Debugw("grpc-web server stopped", "err", err)
+		}
+	}()
+
+	return func() {
+		httpServer.Close()
+		h.conns.close()
+	}, nil
+}
+
+// grpcWebHandler translates gRPC-Web requests into gRPC calls to the
+// server, which it reaches in-process over one connection per libp2p
+// stream.
+type grpcWebHandler struct {
+	conns *streamConns
+}
+
+func (h *grpcWebHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	contentType := r.Header.Get("Content-Type")
+	text := strings.HasPrefix(contentType, grpcWebTextContentType)
+	if r.Method != http.MethodPost || !strings.HasPrefix(contentType, grpcWebContentType) {
+		http.Error(w, "libp2pgrpc: not a gRPC-Web request", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	conn, ok := streamConnFromContext(r.Context())
+	if !ok {
+		http.Error(w, "libp2pgrpc: not a libp2p stream", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	out := &grpcWebWriter{w: w, text: text}
+
+	ctx, cancel, err := grpcWebContext(r)
+	if err != nil {
+		out.writeTrailer(nil, err)
+		return
+	}
+	defer cancel()
+
+	if err := h.conns.server.admitStreamConn(conn); err != nil {
+		out.writeTrailer(nil, err)
+		return
+	}
+	if err := h.conns.dial(conn); err != nil {
+		out.writeTrailer(nil, status.Error(codes.Unavailable, err.Error()))
+		return
+	}
+
+	var body io.Reader = r.Body
+	if text {
+		body = newBase64Reader(r.Body)
+	}
+	trailer, err := proxyGrpcWeb(ctx, conn.cc, r.URL.Path, body, h.conns.server.maxRecvMsgSize, out)
+	out.writeTrailer(trailer, err)
+}
+
+// proxyGrpcWeb forwards the framed messages in body to method and
+// writes the responses to out. It returns the trailers and status of
+// the call.
+func proxyGrpcWeb(ctx context.Context, cc *grpc.ClientConn, method string, body io.Reader, maxRecv int, out *grpcWebWriter) (metadata.MD, error) {
+	desc := &grpc.StreamDesc{ServerStreams: true, ClientStreams: true}
+	stream, err := cc.NewStream(ctx, desc, method, grpc.ForceCodec(rawCodec{}))
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		flag, msg, err := readGrpcWebFrame(body, maxRecv)
+		if err == io.EOF {
+			break
+		}
+		var tooLarge *frameTooLargeError
+		if errors.As(err, &tooLarge) {
+			return nil, status.Error(codes.ResourceExhausted, "libp2pgrpc: "+err.Error())
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "libp2pgrpc: reading gRPC-Web request: %s", err)
+		}
+		if flag != 0 {
+			return nil, status.Error(codes.Unimplemented, "libp2pgrpc: compressed gRPC-Web messages are not supported")
+		}
+		// a failed send ends the call; its status is returned by RecvMsg
+		if err := stream.SendMsg(&msg); err != nil {
+			break
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+
+	if header, err := stream.Header(); err == nil {
+		out.writeHeader(header)
+	}
+	for {
+		var msg []byte
+		if err := stream.RecvMsg(&msg); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return stream.Trailer(), err
+		}
+		if err := out.writeFrame(0, msg); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// grpcWebContext returns the context for the call carried by r, with
+// the request metadata and deadline.
+func grpcWebContext(r *http.Request) (context.Context, context.CancelFunc, error) {
+	md := metadata.MD{}
+	for k, vs := range r.Header {
+		k = strings.ToLower(k)
+		if grpcWebSkipHeader(k) {
+			continue
+		}
+		for _, v := range vs {
+			v, err := decodeMetadataValue(k, v)
+			if err != nil {
+				return nil, nil, status.Errorf(codes.InvalidArgument, "libp2pgrpc: malformed header %s: %s", k, err)
+			}
+			md.Append(k, v)
+		}
+	}
+	ctx := metadata.NewOutgoingContext(r.Context(), md)
+
+	if v := r.Header.Get("Grpc-Timeout"); v != "" {
+		timeout, err := parseGrpcTimeout(v)
+		if err != nil {
+			return nil, nil, status.Errorf(codes.InvalidArgument, "libp2pgrpc: malformed grpc-timeout: %s", err)
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		return ctx, cancel, nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return ctx, cancel, nil
+}
+
+// grpcWebSkipHeader reports whether the HTTP header k is not forwarded
+// as request metadata.
+func grpcWebSkipHeader(k string) bool {
+	switch k {
+	case "accept", "accept-encoding", "connection", "content-length", "content-type", "host",
+		"origin", "referer", "te", "transfer-encoding", "user-agent", "x-grpc-web", "x-user-agent":
+		return true
+	}
+	return strings.HasPrefix(k, "grpc-") || strings.HasPrefix(k, "access-control-") || strings.HasPrefix(k, "sec-")
+}
+
+// grpcWebWriter writes the framed response of a gRPC-Web call.
+type grpcWebWriter struct {
+	w           http.ResponseWriter
+	text        bool
+	wroteHeader bool
+}
+
+func (ww *grpcWebWriter) writeHeader(md metadata.MD) {
+	if ww.wroteHeader {
+		return
+	}
+	ww.wroteHeader = true
+
+	h := ww.w.Header()
+	for k, vs := range md {
+		if k == "content-type" {
+			continue
+		}
+		for _, v := range vs {
+			h.Add(k, encodeMetadataValue(k, v))
+		}
+	}
+	ww.w.WriteHeader(http.StatusOK)
+}
+
+func (ww *grpcWebWriter) writeFrame(flag byte, data []byte) error {
+	ww.writeHeader(nil)
+
+	if _, err := ww.w.Write(grpcWebFrame(flag, data, ww.text)); err != nil {
+		return err
+	}
+	if f, ok := ww.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func (ww *grpcWebWriter) writeTrailer(trailer metadata.MD, err error) {
+	ww.writeFrame(grpcWebTrailerFlag, grpcWebTrailer(trailer, err))
+}
+
+// grpcWebFrame encodes one frame of a gRPC-Web response.
+func grpcWebFrame(flag byte, data []byte, text bool) []byte {
+	frame := make([]byte, 5+len(data))
+	frame[0] = flag
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(data)))
+	copy(frame[5:], data)
+	if text {
+		encoded := make([]byte, base64.StdEncoding.EncodedLen(len(frame)))
+		base64.StdEncoding.Encode(encoded, frame)
+		frame = encoded
+	}
+	return frame
+}
+
+// grpcWebTrailer encodes the trailers and the status of err as the
+// payload of a trailer frame.
+func grpcWebTrailer(trailer metadata.MD, err error) []byte {
+	st := status.Convert(err)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "grpc-status: %d\r\n", st.Code())
+	if msg := st.Message(); msg != "" {
+		fmt.Fprintf(&buf, "grpc-message: %s\r\n", encodeGrpcMessage(msg))
+	}
+	if len(st.Details()) > 0 {
+		if details, err := proto.Marshal(st.Proto()); err == nil {
+			fmt.Fprintf(&buf, "grpc-status-details-bin: %s\r\n", base64.StdEncoding.EncodeToString(details))
+		}
+	}
+	for k, vs := range trailer {
+		for _, v := range vs {
+			fmt.Fprintf(&buf, "%s: %s\r\n", k, encodeMetadataValue(k, v))
+		}
+	}
+	return buf.Bytes()
+}
+
+// readGrpcWebFrame reads one length-prefixed frame of at most limit
+// bytes. It returns io.EOF only if r ends before the frame starts.
+func readGrpcWebFrame(r io.Reader, limit int) (byte, []byte, error) {
+	var hdr [5]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+
+	data, err := readPayload(r, uint64(binary.BigEndian.Uint32(hdr[1:])), limit)
+	if err != nil {
+		return 0, nil, err
+	}
+	return hdr[0], data, nil
+}
+
+// base64Reader decodes application/grpc-web-text bodies. Those are
+// made of separately padded base64 chunks, so every 4 byte quantum is
+// decoded on its own.
+type base64Reader struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func newBase64Reader(r io.Reader) *base64Reader {
+	return &base64Reader{r: bufio.NewReader(r)}
+}
+
+func (b *base64Reader) Read(p []byte) (int, error) {
+	for len(b.buf) == 0 {
+		var quantum [4]byte
+		if _, err := io.ReadFull(b.r, quantum[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = errors.New("truncated base64 data")
+			}
+			return 0, err
+		}
+
+		var decoded [3]byte
+		n, err := base64.StdEncoding.Decode(decoded[:], quantum[:])
+		if err != nil {
+			return 0, err
+		}
+		b.buf = decoded[:n]
+	}
+
+	n := copy(p, b.buf)
+	b.buf = b.buf[n:]
+	return n, nil
+}
+
+// rawCodec passes already encoded messages through. It is named
+// "proto" so the server decodes them with its proto codec.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	return *(v.(*[]byte)), nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	*(v.(*[]byte)) = append([]byte(nil), data...)
+	return nil
+}
+
+func (rawCodec) Name() string {
+	return "proto"
+}
+
+func encodeMetadataValue(k, v string) string {
+	if strings.HasSuffix(k, "-bin") {
+		return base64.StdEncoding.EncodeToString([]byte(v))
+	}
+	return v
+}
+
+func decodeMetadataValue(k, v string) (string, error) {
+	if !strings.HasSuffix(k, "-bin") {
+		return v, nil
+	}
+
+	enc := base64.StdEncoding
+	if len(v)%4 != 0 {
+		enc = base64.RawStdEncoding
+	}
+	b, err := enc.DecodeString(v)
+	return string(b), err
+}
+
+// encodeGrpcMessage percent-encodes msg as the grpc-message header
+// requires.
+func encodeGrpcMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if c := msg[i]; c >= ' ' && c <= '~' && c != '%' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func decodeGrpcMessage(msg string) string {
+	if s, err := url.PathUnescape(msg); err == nil {
+		return s
+	}
+	return msg
+}
+
+var grpcTimeoutUnits = []struct {
+	unit   time.Duration
+	suffix byte
+}{
+	{time.Nanosecond, 'n'},
+	{time.Microsecond, 'u'},
+	{time.Millisecond, 'm'},
+	{time.Second, 'S'},
+	{time.Minute, 'M'},
+	{time.Hour, 'H'},
+}
+
+func parseGrpcTimeout(v string) (time.Duration, error) {
+	if len(v) < 2 || len(v) > 9 {
+		return 0, fmt.Errorf("invalid timeout %q", v)
+	}
+
+	n, err := strconv.ParseInt(v[:len(v)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid timeout %q", v)
+	}
+	for _, u := range grpcTimeoutUnits {
+		if u.suffix == v[len(v)-1] {
+			return time.Duration(n) * u.unit, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid timeout unit in %q", v)
+}
+
+// encodeGrpcTimeout formats d with at most 8 digits, rounding up.
+func encodeGrpcTimeout(d time.Duration) string {
+	if d <= 0 {
+		return "0n"
+	}
+	for _, u := range grpcTimeoutUnits {
+		if n := (d + u.unit - 1) / u.unit; n < 1e8 {
+			return strconv.FormatInt(int64(n), 10) + string(u.suffix)
+		}
+	}
+	return "99999999H"
+}
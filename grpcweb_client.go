@@ -0,0 +1,308 @@
+package libp2pgrpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	gostream "github.com/libp2p/go-libp2p-gostream"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	grpcproto "google.golang.org/grpc/encoding/proto"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+var _ grpc.ClientConnInterface = &GrpcWebConn{}
+
+// GrpcWebOption configures a GrpcWebConn.
+type GrpcWebOption func(*GrpcWebConn)
+
+// WithGrpcWebProtocol makes the connection use the given protocol ID
+// instead of GrpcWebProtocolID.
+func WithGrpcWebProtocol(p protocol.ID) GrpcWebOption {
+	return func(c *GrpcWebConn) {
+		c.protocol = p
+	}
+}
+
+// WithGrpcWebText makes the connection use the base64 encoded
+// application/grpc-web-text format, which browsers use by default.
+func WithGrpcWebText() GrpcWebOption {
+	return func(c *GrpcWebConn) {
+		c.text = true
+	}
+}
+
+// GrpcWebConn issues RPCs to a peer in the gRPC-Web wire format, as a
+// browser peer would. gRPC-Web has no client streaming, so only unary
+// and server streaming RPCs are supported.
+type GrpcWebConn struct {
+	peer     peer.ID
+	protocol protocol.ID
+	text     bool
+	http     *http.Client
+}
+
+// DialGrpcWeb returns a GrpcWebConn to the given peer. Streams are
+// opened as RPCs are issued.
+func (c *Client) DialGrpcWeb(peerID peer.ID, opts ...GrpcWebOption) *GrpcWebConn {
+	conn := &GrpcWebConn{
+		peer:     peerID,
+		protocol: GrpcWebProtocolID,
+	}
+	for _, opt := range opts {
+		opt(conn)
+	}
+
+	conn.http = &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return gostream.Dial(ctx, c.host, peerID, conn.protocol)
+		},
+	}}
+	return conn
+}
+
+// Close closes the idle streams of the connection.
+func (c *GrpcWebConn) Close() error {
+	c.http.CloseIdleConnections()
+	return nil
+}
+
+// Invoke performs a unary RPC.
+func (c *GrpcWebConn) Invoke(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) error {
+	stream, err := c.NewStream(ctx, &grpc.StreamDesc{}, method, opts...)
+	if err != nil {
+		return err
+	}
+	return invoke(stream.(*grpcWebStream), args, reply)
+}
+
+// NewStream begins a streaming RPC. The request is sent on CloseSend.
+func (c *GrpcWebConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	if desc.ClientStreams {
+		return nil, status.Error(codes.Unimplemented, "libp2pgrpc: gRPC-Web does not support client streaming")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s := &grpcWebStream{
+		clientStream: newClientStream(ctx, cancel, encoding.GetCodec(grpcproto.Name), opts),
+		conn:         c,
+		method:       method,
+	}
+	s.transport = s
+	return s, nil
+}
+
+// grpcWebStream is a gRPC-Web call. The request messages are buffered
+// until CloseSend, since gRPC-Web requests are sent in one piece.
+type grpcWebStream struct {
+	*clientStream
+	conn   *GrpcWebConn
+	method string
+
+	req  bytes.Buffer
+	sent bool
+	body io.ReadCloser
+}
+
+func (s *grpcWebStream) Header() (metadata.MD, error) {
+	s.roundTrip()
+	return s.clientStream.Header()
+}
+
+func (s *grpcWebStream) CloseSend() error {
+	s.roundTrip()
+	return nil
+}
+
+func (s *grpcWebStream) SendMsg(m interface{}) error {
+	if s.sent {
+		return errors.New("libp2pgrpc: SendMsg called after CloseSend")
+	}
+
+	data, err := s.codec.Marshal(m)
+	if err != nil {
+		return status.Errorf(codes.Internal, "libp2pgrpc: marshaling request: %s", err)
+	}
+	var hdr [5]byte
+	binary.BigEndian.PutUint32(hdr[1:], uint32(len(data)))
+	s.req.Write(hdr[:])
+	s.req.Write(data)
+	return nil
+}
+
+func (s *grpcWebStream) nextFrame() ([]byte, bool) {
+	s.roundTrip()
+	if s.outcome() != nil {
+		return nil, false
+	}
+
+	flag, data, err := readGrpcWebFrame(s.body, maxGrpcWebFrameSize)
+	switch {
+	case err == io.EOF:
+		s.end(nil, status.Error(codes.Internal, "libp2pgrpc: gRPC-Web response without trailers"))
+	case err != nil:
+		s.end(nil, s.transportError(err))
+	case flag&grpcWebTrailerFlag != 0:
+		s.end(parseGrpcWebTrailer(data))
+	case flag != 0:
+		s.end(nil, status.Error(codes.Internal, "libp2pgrpc: compressed gRPC-Web messages are not supported"))
+	default:
+		return data, true
+	}
+	return nil, false
+}
+
+func (s *grpcWebStream) roundTrip() {
+	if s.sent {
+		return
+	}
+	s.sent = true
+
+	var body io.Reader = &s.req
+	contentType := grpcWebContentType + "+proto"
+	if s.conn.text {
+		body = strings.NewReader(base64.StdEncoding.EncodeToString(s.req.Bytes()))
+		contentType = grpcWebTextContentType + "+proto"
+	}
+
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodPost, "http://"+s.conn.peer.String()+s.method, body)
+	if err != nil {
+		s.end(nil, status.Error(codes.Internal, err.Error()))
+		return
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", contentType)
+	req.Header.Set("X-Grpc-Web", "1")
+	if deadline, ok := s.ctx.Deadline(); ok {
+		req.Header.Set("Grpc-Timeout", encodeGrpcTimeout(time.Until(deadline)))
+	}
+	md, _ := metadata.FromOutgoingContext(s.ctx)
+	for k, vs := range md {
+		for _, v := range vs {
+			req.Header.Add(k, encodeMetadataValue(k, v))
+		}
+	}
+
+	res, err := s.conn.http.Do(req)
+	if err != nil {
+		s.end(nil, s.transportError(err))
+		return
+	}
+	s.body = res.Body
+
+	header := metadata.MD{}
+	for k, vs := range res.Header {
+		k = strings.ToLower(k)
+		for _, v := range vs {
+			if v, err := decodeMetadataValue(k, v); err == nil {
+				header.Append(k, v)
+			}
+		}
+	}
+	s.setHeader(header)
+
+	switch {
+	case res.Header.Get("Grpc-Status") != "":
+		// a trailers-only response
+		s.end(parseGrpcWebTrailer(headerTrailer(res.Header)))
+	case res.StatusCode != http.StatusOK:
+		s.end(nil, status.Errorf(httpStatusCode(res.StatusCode), "libp2pgrpc: unexpected HTTP status %s", res.Status))
+	case strings.HasPrefix(res.Header.Get("Content-Type"), grpcWebTextContentType):
+		s.body = struct {
+			io.Reader
+			io.Closer
+		}{newBase64Reader(res.Body), res.Body}
+	}
+}
+
+func (s *grpcWebStream) release(error) {
+	if s.body != nil {
+		s.body.Close()
+	}
+}
+
+// parseGrpcWebTrailer parses a trailer frame into the trailer metadata
+// and the status of the call.
+func parseGrpcWebTrailer(data []byte) (metadata.MD, error) {
+	trailer := metadata.MD{}
+	code, msg := codes.Unknown, ""
+	var details *spb.Status
+
+	for _, line := range strings.Split(string(data), "\r\n") {
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		k, v = strings.ToLower(strings.TrimSpace(k)), strings.TrimSpace(v)
+
+		switch k {
+		case "grpc-status":
+			n, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return trailer, status.Errorf(codes.Internal, "libp2pgrpc: malformed grpc-status %q", v)
+			}
+			code = codes.Code(n)
+		case "grpc-message":
+			msg = decodeGrpcMessage(v)
+		case "grpc-status-details-bin":
+			if b, err := decodeMetadataValue(k, v); err == nil {
+				details = &spb.Status{}
+				if proto.Unmarshal([]byte(b), details) != nil {
+					details = nil
+				}
+			}
+		default:
+			if v, err := decodeMetadataValue(k, v); err == nil {
+				trailer.Append(k, v)
+			}
+		}
+	}
+
+	return trailer, callStatus(code, msg, details)
+}
+
+// headerTrailer renders the trailers of a trailers-only response, which
+// come as HTTP headers, like a trailer frame.
+func headerTrailer(h http.Header) []byte {
+	var buf bytes.Buffer
+	for k, vs := range h {
+		for _, v := range vs {
+			buf.WriteString(k + ": " + v + "\r\n")
+		}
+	}
+	return buf.Bytes()
+}
+
+// httpStatusCode maps the HTTP status of a response without gRPC status
+// to a gRPC code, as gRPC clients do.
+func httpStatusCode(code int) codes.Code {
+	switch code {
+	case http.StatusBadRequest:
+		return codes.Internal
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.Unimplemented
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return codes.Unavailable
+	default:
+		return codes.Unknown
+	}
+}
@@ -0,0 +1,164 @@
+package libp2pgrpc_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
+	proto "github.com/drgomesp/go-libp2p-grpc/proto/v1"
+)
+
+type grpcWebTestService struct {
+	testpb.UnimplementedTestServiceServer
+}
+
+func (grpcWebTestService) UnaryCall(ctx context.Context, req *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	grpc.SetHeader(ctx, metadata.MD{"x-echo": md.Get("x-token")})
+	grpc.SetTrailer(ctx, metadata.Pairs("x-trailer-bin", "\x00\x01"))
+
+	if st := req.GetResponseStatus(); st != nil {
+		return nil, status.Error(codes.Code(st.GetCode()), st.GetMessage())
+	}
+	return &testpb.SimpleResponse{Payload: req.GetPayload()}, nil
+}
+
+func (grpcWebTestService) StreamingOutputCall(req *testpb.StreamingOutputCallRequest, stream testpb.TestService_StreamingOutputCallServer) error {
+	for _, params := range req.GetResponseParameters() {
+		payload := &testpb.Payload{Body: bytes.Repeat([]byte{'a'}, int(params.GetSize()))}
+		if err := stream.Send(&testpb.StreamingOutputCallResponse{Payload: payload}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newGrpcWebHosts(t *testing.T) (host.Host, host.Host) {
+	mn, err := mocknet.FullMeshConnected(2)
+	assert.NoError(t, err)
+	t.Cleanup(func() { mn.Close() })
+	return mn.Hosts()[0], mn.Hosts()[1]
+}
+
+func serveGrpcWeb(t *testing.T, srvHost host.Host) *peerRecordingService {
+	srv, err := libp2pgrpc.NewGrpcServer(context.Background(), srvHost, libp2pgrpc.WithGrpcWeb())
+	assert.NoError(t, err)
+	t.Cleanup(srv.Stop)
+
+	svc := &peerRecordingService{NodeInfoService: NodeInfoService{host: srvHost}}
+	proto.RegisterNodeServiceServer(srv, svc)
+	testpb.RegisterTestServiceServer(srv, grpcWebTestService{})
+	go srv.Serve()
+	return svc
+}
+
+// waitGrpcWeb waits for the gRPC-Web protocol to be served, since
+// gRPC-Web calls have no equivalent of grpc.WaitForReady.
+func waitGrpcWeb(t *testing.T, conn *libp2pgrpc.GrpcWebConn) {
+	assert.Eventually(t, func() bool {
+		_, err := proto.NewNodeServiceClient(conn).Info(context.Background(), &proto.NodeInfoRequest{})
+		return err == nil
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestGrpcWebUnary(t *testing.T) {
+	srvHost, cliHost := newGrpcWebHosts(t)
+	svc := serveGrpcWeb(t, srvHost)
+
+	conn := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID).DialGrpcWeb(srvHost.ID())
+	defer conn.Close()
+	waitGrpcWeb(t, conn)
+
+	res, err := proto.NewNodeServiceClient(conn).Info(context.Background(), &proto.NodeInfoRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, srvHost.ID().String(), res.GetId())
+
+	if assert.NotNil(t, svc.peer) {
+		assert.Equal(t, cliHost.ID(), svc.peer.ID)
+		assert.Equal(t, libp2pgrpc.GrpcWebProtocolID, svc.peer.Protocol)
+	}
+}
+
+func TestGrpcWebMetadataAndStatus(t *testing.T) {
+	for name, opts := range map[string][]libp2pgrpc.GrpcWebOption{
+		"binary": nil,
+		"text":   {libp2pgrpc.WithGrpcWebText()},
+	} {
+		t.Run(name, func(t *testing.T) {
+			srvHost, cliHost := newGrpcWebHosts(t)
+			serveGrpcWeb(t, srvHost)
+
+			conn := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID).DialGrpcWeb(srvHost.ID(), opts...)
+			defer conn.Close()
+			waitGrpcWeb(t, conn)
+			client := testpb.NewTestServiceClient(conn)
+
+			ctx := metadata.AppendToOutgoingContext(context.Background(), "x-token", "secret")
+			var header, trailer metadata.MD
+			res, err := client.UnaryCall(ctx, &testpb.SimpleRequest{
+				Payload: &testpb.Payload{Body: []byte("hello")},
+			}, grpc.Header(&header), grpc.Trailer(&trailer))
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("hello"), res.GetPayload().GetBody())
+			assert.Equal(t, []string{"secret"}, header.Get("x-echo"))
+			assert.Equal(t, []string{"\x00\x01"}, trailer.Get("x-trailer-bin"))
+
+			_, err = client.UnaryCall(ctx, &testpb.SimpleRequest{
+				ResponseStatus: &testpb.EchoStatus{Code: int32(codes.NotFound), Message: "no such thing: 100%"},
+			})
+			assert.Equal(t, codes.NotFound, status.Code(err))
+			assert.Equal(t, "no such thing: 100%", status.Convert(err).Message())
+
+			stream, err := client.StreamingOutputCall(ctx, &testpb.StreamingOutputCallRequest{
+				ResponseParameters: []*testpb.ResponseParameters{{Size: 1}, {Size: 10}, {Size: 100 << 10}},
+			})
+			assert.NoError(t, err)
+			var sizes []int
+			for {
+				res, err := stream.Recv()
+				if err == io.EOF {
+					break
+				}
+				if !assert.NoError(t, err) {
+					break
+				}
+				sizes = append(sizes, len(res.GetPayload().GetBody()))
+			}
+			assert.Equal(t, []int{1, 10, 100 << 10}, sizes)
+		})
+	}
+}
+
+func TestGrpcWebUnsupportedCalls(t *testing.T) {
+	srvHost, cliHost := newGrpcWebHosts(t)
+	serveGrpcWeb(t, srvHost)
+
+	conn := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID).DialGrpcWeb(srvHost.ID())
+	defer conn.Close()
+	waitGrpcWeb(t, conn)
+	client := testpb.NewTestServiceClient(conn)
+
+	_, err := client.FullDuplexCall(context.Background())
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	_, err = client.EmptyCall(context.Background(), &testpb.Empty{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	_, err = client.UnaryCall(ctx, &testpb.SimpleRequest{})
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+}
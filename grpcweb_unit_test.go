@@ -0,0 +1,63 @@
+package libp2pgrpc
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGrpcTimeout(t *testing.T) {
+	for _, d := range []time.Duration{time.Nanosecond, 1500 * time.Microsecond, 3 * time.Second, 200 * time.Hour} {
+		parsed, err := parseGrpcTimeout(encodeGrpcTimeout(d))
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, parsed, d)
+	}
+	assert.Equal(t, "3000000u", encodeGrpcTimeout(3*time.Second))
+
+	for _, v := range []string{"", "1", "10x", "-1S", "123456789S"} {
+		_, err := parseGrpcTimeout(v)
+		assert.Error(t, err, v)
+	}
+}
+
+func TestBase64ReaderChunks(t *testing.T) {
+	body := base64.StdEncoding.EncodeToString([]byte("a")) +
+		base64.StdEncoding.EncodeToString([]byte("bc")) +
+		base64.StdEncoding.EncodeToString([]byte("def"))
+
+	data, err := io.ReadAll(newBase64Reader(strings.NewReader(body)))
+	assert.NoError(t, err)
+	assert.Equal(t, "abcdef", string(data))
+
+	_, err = io.ReadAll(newBase64Reader(strings.NewReader("YWJj!")))
+	assert.Error(t, err)
+}
+
+func TestGrpcMessageEncoding(t *testing.T) {
+	msg := "100% done\nnext: é"
+	encoded := encodeGrpcMessage(msg)
+	assert.Equal(t, "100%25 done%0Anext: %C3%A9", encoded)
+	assert.Equal(t, msg, decodeGrpcMessage(encoded))
+}
+
+func TestReadGrpcWebFrameLimit(t *testing.T) {
+	frame := []byte{0, 0, 0, 0, 3, 'a', 'b', 'c'}
+	flag, data, err := readGrpcWebFrame(bytes.NewReader(frame), 3)
+	assert.NoError(t, err)
+	assert.Equal(t, byte(0), flag)
+	assert.Equal(t, "abc", string(data))
+
+	var tooLarge *frameTooLargeError
+	_, _, err = readGrpcWebFrame(bytes.NewReader(frame), 2)
+	assert.True(t, errors.As(err, &tooLarge))
+
+	// a length prefix alone doesn't get its payload allocated
+	_, _, err = readGrpcWebFrame(bytes.NewReader([]byte{0, 0x01, 0, 0, 0}), 64<<20)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
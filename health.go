@@ -0,0 +1,82 @@
+package libp2pgrpc
+
+import (
+	"context"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+// WithReflection registers the gRPC server reflection service, so
+// generic tools can list and describe the services a peer offers.
+func WithReflection() ServerOption {
+	return ServerOption{apply: func(s *Server) {
+		s.reflection = true
+	}}
+}
+
+// WithHealth registers the standard gRPC health checking service
+// (grpc.health.v1.Health). The overall status and the status of every
+// registered service become SERVING once Serve is called, and
+// NOT_SERVING as soon as the server starts stopping.
+func WithHealth() ServerOption {
+	return ServerOption{apply: func(s *Server) {
+		s.health = health.NewServer()
+		s.health.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	}}
+}
+
+// Health returns the health service registered through WithHealth, or
+// nil. It can be used to report the status of individual services.
+func (s *Server) Health() *health.Server {
+	return s.health
+}
+
+// registerBuiltinServices registers the services enabled through
+// server options on the gRPC server.
+func (s *Server) registerBuiltinServices() {
+	if s.health != nil {
+		healthpb.RegisterHealthServer(s, s.health)
+	}
+	if s.reflection {
+		reflection.Register(s)
+	}
+}
+
+// markServing sets the status of every registered service to SERVING.
+func (s *Server) markServing() {
+	if s.health == nil {
+		return
+	}
+	s.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	for name := range s.grpc.GetServiceInfo() {
+		s.health.SetServingStatus(name, healthpb.HealthCheckResponse_SERVING)
+	}
+}
+
+// markNotServing sets the status of every registered service to
+// NOT_SERVING for good.
+func (s *Server) markNotServing() {
+	if s.health != nil {
+		s.health.Shutdown()
+	}
+}
+
+// CheckHealth asks the given peer for the health of a service, or for
+// its overall health if service is empty.
+func (c *Client) CheckHealth(ctx context.Context, peerID peer.ID, service string, dialOpts ...grpc.DialOption) (healthpb.HealthCheckResponse_ServingStatus, error) {
+	conn, err := c.Dial(ctx, peerID, dialOpts...)
+	if err != nil {
+		return healthpb.HealthCheckResponse_UNKNOWN, err
+	}
+	defer conn.Close()
+
+	res, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		return healthpb.HealthCheckResponse_UNKNOWN, err
+	}
+	return res.GetStatus(), nil
+}
@@ -0,0 +1,92 @@
+package libp2pgrpc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+
+	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
+	proto "github.com/drgomesp/go-libp2p-grpc/proto/v1"
+)
+
+func TestHealthFollowsLifecycle(t *testing.T) {
+	ctx := context.Background()
+	srvHost, cliHost := newConnectedHosts(t)
+
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost, libp2pgrpc.WithHealth())
+	assert.NoError(t, err)
+	defer srv.Stop()
+	serveNodeInfo(t, srv, srvHost)
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	for _, service := range []string{"", proto.NodeService_ServiceDesc.ServiceName} {
+		status, err := client.CheckHealth(ctx, srvHost.ID(), service)
+		assert.NoError(t, err)
+		assert.Equal(t, healthpb.HealthCheckResponse_SERVING, status, service)
+	}
+
+	_, err = client.CheckHealth(ctx, srvHost.ID(), "unknown.Service")
+	assert.Error(t, err)
+
+	conn, err := client.Dial(ctx, srvHost.ID())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	watchCtx, cancelWatch := context.WithCancel(ctx)
+	defer cancelWatch()
+	watch, err := healthpb.NewHealthClient(conn).Watch(watchCtx, &healthpb.HealthCheckRequest{})
+	assert.NoError(t, err)
+	res, err := watch.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, res.GetStatus())
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		stopped <- srv.GracefulStop(stopCtx)
+	}()
+
+	// the open watch keeps the graceful stop waiting, and sees the
+	// server going down
+	res, err = watch.Recv()
+	assert.NoError(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, res.GetStatus())
+
+	cancelWatch()
+	assert.NoError(t, <-stopped)
+}
+
+func TestReflection(t *testing.T) {
+	ctx := context.Background()
+	srvHost, cliHost := newConnectedHosts(t)
+
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost, libp2pgrpc.WithReflection())
+	assert.NoError(t, err)
+	defer srv.Stop()
+	serveNodeInfo(t, srv, srvHost)
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	conn, err := client.Dial(ctx, srvHost.ID())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Send(&reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	}))
+	res, err := stream.Recv()
+	assert.NoError(t, err)
+
+	services := make([]string, 0)
+	for _, svc := range res.GetListServicesResponse().GetService() {
+		services = append(services, svc.GetName())
+	}
+	assert.Contains(t, services, proto.NodeService_ServiceDesc.ServiceName)
+	assert.Contains(t, services, "grpc.reflection.v1alpha.ServerReflection")
+}
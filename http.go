@@ -0,0 +1,109 @@
+package libp2pgrpc
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"google.golang.org/grpc/status"
+)
+
+// HTTPProtocolID is the protocol ID the HTTP gateway is served on by
+// default. It matches the default of go-libp2p-http, so its
+// RoundTripper reaches the gateway without extra configuration.
+const HTTPProtocolID protocol.ID = "/libp2p-http"
+
+type httpGateway struct {
+	protocol protocol.ID
+	handlers []RegisterHandlerFunc
+	muxOpts  []runtime.ServeMuxOption
+}
+
+// WithHTTPGateway makes the Server serve the given gRPC-Gateway
+// handlers as HTTP over libp2p, on HTTPProtocolID of its own host.
+// The handlers reach the Server in-process, so no TCP port is needed
+// on either side, and the Server sees each request as coming from the
+// libp2p peer that sent it.
+func WithHTTPGateway(handlers ...RegisterHandlerFunc) ServerOption {
+	return ServerOption{apply: func(s *Server) {
+		s.gatewayConfig().handlers = append(s.gatewayConfig().handlers, handlers...)
+	}}
+}
+
+// WithHTTPGatewayProtocol makes the HTTP gateway listen on the given
+// protocol ID instead of HTTPProtocolID.
+func WithHTTPGatewayProtocol(p protocol.ID) ServerOption {
+	return ServerOption{apply: func(s *Server) {
+		s.gatewayConfig().protocol = p
+	}}
+}
+
+// WithHTTPGatewayMuxOptions sets the options of the runtime.ServeMux
+// behind the HTTP gateway.
+func WithHTTPGatewayMuxOptions(opts ...runtime.ServeMuxOption) ServerOption {
+	return ServerOption{apply: func(s *Server) {
+		s.gatewayConfig().muxOpts = append(s.gatewayConfig().muxOpts, opts...)
+	}}
+}
+
+func (s *Server) gatewayConfig() *httpGateway {
+	if s.gateway == nil {
+		s.gateway = &httpGateway{protocol: HTTPProtocolID}
+	}
+	return s.gateway
+}
+
+// serveHTTP starts the HTTP gateway. Every libp2p stream gets its own
+// in-process connection to the server and gateway mux, so the server
+// sees the requests as coming from the peer that sent them. The
+// returned function stops it.
+func (s *Server) serveHTTP(ctx context.Context) (func(), error) {
+	listener, err := _gostream_Listen(s.host, s.gateway.protocol)
+	if err != nil {
+		return nil, err
+	}
+	limited := s.meterListener(s.limitListener(listener))
+
+	conns := newStreamConns(s, func(sc *streamConn) error {
+		mux := runtime.NewServeMux(s.gateway.muxOpts...)
+		for _, register := range s.gateway.handlers {
+			if err := register(ctx, mux, sc.cc); err != nil {
+				return err
+			}
+		}
+		sc.handler = mux
+		return nil
+	})
+
+	httpServer := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, ok := streamConnFromContext(r.Context())
+			if !ok {
+				http.Error(w, "libp2pgrpc: not a libp2p stream", http.StatusBadRequest)
+				return
+			}
+			if err := s.admitStreamConn(conn); err != nil {
+				http.Error(w, status.Convert(err).Message(), runtime.HTTPStatusFromCode(status.Code(err)))
+				return
+			}
+			if err := conns.dial(conn); err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			conn.handler.ServeHTTP(w, r)
+		}),
+		ConnContext: conns.connContext,
+		ConnState:   conns.connState,
+	}
+	go func() {
+		if err := httpServer.Serve(limited); err != nil && err != http.ErrServerClosed {
+			log.Debugw("http gateway stopped", "protocol", s.gateway.protocol, "err", err)
+		}
+	}()
+
+	return func() {
+		httpServer.Close()
+		conns.close()
+	}, nil
+}
@@ -0,0 +1,164 @@
+package libp2pgrpc_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	p2phttp "github.com/libp2p/go-libp2p-http"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/stretchr/testify/assert"
+
+	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
+	proto "github.com/drgomesp/go-libp2p-grpc/proto/v1"
+)
+
+// fetchNodeInfo polls url until the gateway answers, since Serve sets
+// up the HTTP listener in the background.
+func fetchNodeInfo(t *testing.T, client *http.Client, url string) *proto.NodeInfoResponse {
+	var info *proto.NodeInfoResponse
+	assert.Eventually(t, func() bool {
+		res, err := client.Get(url)
+		if err != nil {
+			return false
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return false
+		}
+
+		info = &proto.NodeInfoResponse{}
+		return json.NewDecoder(res.Body).Decode(info) == nil
+	}, 2*time.Second, 10*time.Millisecond)
+	return info
+}
+
+func TestHTTPGatewayOverLibp2p(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn, err := mocknet.FullMeshConnected(2)
+	assert.NoError(t, err)
+	defer mn.Close()
+	srvHost, cliHost := mn.Hosts()[0], mn.Hosts()[1]
+
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost,
+		libp2pgrpc.WithHTTPGateway(proto.RegisterNodeServiceHandler),
+	)
+	assert.NoError(t, err)
+	proto.RegisterNodeServiceServer(srv, &NodeInfoService{host: srvHost})
+	go srv.Serve()
+	defer srv.Stop()
+
+	tr := &http.Transport{}
+	tr.RegisterProtocol("libp2p", p2phttp.NewTransport(cliHost))
+	httpClient := &http.Client{Transport: tr}
+
+	info := fetchNodeInfo(t, httpClient, "libp2p://"+srvHost.ID().String()+"/v1/node/info")
+	assert.Equal(t, srvHost.ID().String(), info.GetId())
+}
+
+func TestHTTPGatewayCustomProtocol(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn, err := mocknet.FullMeshConnected(2)
+	assert.NoError(t, err)
+	defer mn.Close()
+	srvHost, cliHost := mn.Hosts()[0], mn.Hosts()[1]
+
+	httpProto := protocol.ID("/nodeinfo/http/1.0.0")
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost,
+		libp2pgrpc.WithHTTPGateway(proto.RegisterNodeServiceHandler),
+		libp2pgrpc.WithHTTPGatewayProtocol(httpProto),
+	)
+	assert.NoError(t, err)
+	proto.RegisterNodeServiceServer(srv, &NodeInfoService{host: srvHost})
+	go srv.Serve()
+	defer srv.Stop()
+
+	tr := &http.Transport{}
+	tr.RegisterProtocol("libp2p", p2phttp.NewTransport(cliHost, p2phttp.ProtocolOption(httpProto)))
+	httpClient := &http.Client{Transport: tr}
+
+	info := fetchNodeInfo(t, httpClient, "libp2p://"+srvHost.ID().String()+"/v1/node/info")
+	assert.Equal(t, srvHost.ID().String(), info.GetId())
+
+	_, err = cliHost.NewStream(ctx, srvHost.ID(), libp2pgrpc.HTTPProtocolID)
+	assert.Error(t, err)
+}
+
+func TestHTTPGatewayAuthorizesRemotePeer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn, err := mocknet.FullMeshConnected(3)
+	assert.NoError(t, err)
+	defer mn.Close()
+	srvHost, allowedHost, deniedHost := mn.Hosts()[0], mn.Hosts()[1], mn.Hosts()[2]
+
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost,
+		libp2pgrpc.WithHTTPGateway(proto.RegisterNodeServiceHandler),
+		libp2pgrpc.WithAuthorization(libp2pgrpc.AllowPeers(allowedHost.ID())),
+	)
+	assert.NoError(t, err)
+	proto.RegisterNodeServiceServer(srv, &NodeInfoService{host: srvHost})
+	go srv.Serve()
+	defer srv.Stop()
+
+	url := "libp2p://" + srvHost.ID().String() + "/v1/node/info"
+	httpClient := func(h host.Host) *http.Client {
+		tr := &http.Transport{}
+		tr.RegisterProtocol("libp2p", p2phttp.NewTransport(h))
+		return &http.Client{Transport: tr}
+	}
+
+	info := fetchNodeInfo(t, httpClient(allowedHost), url)
+	assert.Equal(t, srvHost.ID().String(), info.GetId())
+
+	res, err := httpClient(deniedHost).Get(url)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+}
+
+func TestHTTPGatewayMaxPeerConns(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mn, err := mocknet.FullMeshConnected(2)
+	assert.NoError(t, err)
+	defer mn.Close()
+	srvHost, cliHost := mn.Hosts()[0], mn.Hosts()[1]
+
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost,
+		libp2pgrpc.WithHTTPGateway(proto.RegisterNodeServiceHandler),
+		libp2pgrpc.WithMaxPeerConns(1),
+	)
+	assert.NoError(t, err)
+	proto.RegisterNodeServiceServer(srv, &NodeInfoService{host: srvHost})
+	go srv.Serve()
+	defer srv.Stop()
+
+	// a gRPC connection takes the slot of the peer
+	conn := dialNode(t, cliHost, srvHost)
+	assert.NoError(t, callNode(conn))
+
+	tr := &http.Transport{}
+	tr.RegisterProtocol("libp2p", p2phttp.NewTransport(cliHost))
+	httpClient := &http.Client{Transport: tr}
+	url := "libp2p://" + srvHost.ID().String() + "/v1/node/info"
+
+	res, err := httpClient.Get(url)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+
+	conn.Close()
+	info := fetchNodeInfo(t, httpClient, url)
+	assert.Equal(t, srvHost.ID().String(), info.GetId())
+}
@@ -0,0 +1,154 @@
+package libp2pgrpc
+
+import (
+	"net"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/network"
+)
+
+// multiListener fans in the connections accepted by several
+// listeners, so a single grpc.Server can serve all of them.
+type multiListener struct {
+	listeners []net.Listener
+	accepted  chan acceptResult
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+func newMultiListener(listeners ...net.Listener) net.Listener {
+	if len(listeners) == 1 {
+		return listeners[0]
+	}
+
+	ml := &multiListener{
+		listeners: listeners,
+		accepted:  make(chan acceptResult),
+		done:      make(chan struct{}),
+	}
+	for _, l := range listeners {
+		go ml.acceptLoop(l)
+	}
+	return ml
+}
+
+func (ml *multiListener) acceptLoop(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		select {
+		case ml.accepted <- acceptResult{conn: conn, err: err}:
+		case <-ml.done:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Accept returns the next connection accepted by any of the
+// underlying listeners.
+func (ml *multiListener) Accept() (net.Conn, error) {
+	select {
+	case res := <-ml.accepted:
+		return res.conn, res.err
+	case <-ml.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes every underlying listener.
+func (ml *multiListener) Close() error {
+	var err error
+	ml.closeOnce.Do(func() {
+		close(ml.done)
+		for _, l := range ml.listeners {
+			if cerr := l.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+	})
+	return err
+}
+
+// Addr returns the address of the first underlying listener, which
+// for libp2p listeners is the host's peer ID.
+func (ml *multiListener) Addr() net.Addr {
+	return ml.listeners[0].Addr()
+}
+
+// hookedConn is a libp2p stream used as a net.Conn, with hooks run as
+// it is used. The listeners and dialers watching a stream all add their
+// hooks to the same hookedConn. It is still a network.Stream, so the
+// credentials can describe the peer.
+type hookedConn struct {
+	network.Stream
+	conn net.Conn
+
+	// admission is set on the streams accepted by a resourceListener.
+	admission *admission
+
+	onRead  []func(n int, err error)
+	onWrite []func(n int)
+	// onClose runs once, whether the stream is closed or reset.
+	onClose   []func()
+	closeOnce sync.Once
+}
+
+// hookConn returns conn as a hookedConn, wrapping it unless it is one
+// already. It returns false if conn is not a libp2p stream.
+func hookConn(conn net.Conn) (*hookedConn, bool) {
+	if c, ok := conn.(*hookedConn); ok {
+		return c, true
+	}
+	stream, ok := conn.(network.Stream)
+	if !ok {
+		return nil, false
+	}
+	return &hookedConn{Stream: stream, conn: conn}, true
+}
+
+func (c *hookedConn) LocalAddr() net.Addr  { return c.conn.LocalAddr() }
+func (c *hookedConn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+func (c *hookedConn) Read(b []byte) (int, error) {
+	n, err := c.conn.Read(b)
+	for _, hook := range c.onRead {
+		hook(n, err)
+	}
+	return n, err
+}
+
+func (c *hookedConn) Write(b []byte) (int, error) {
+	n, err := c.conn.Write(b)
+	for _, hook := range c.onWrite {
+		hook(n)
+	}
+	return n, err
+}
+
+func (c *hookedConn) Close() error {
+	c.closed()
+	return c.conn.Close()
+}
+
+func (c *hookedConn) Reset() error {
+	c.closed()
+	return c.Stream.Reset()
+}
+
+func (c *hookedConn) closed() {
+	c.closeOnce.Do(func() {
+		for _, hook := range c.onClose {
+			hook()
+		}
+	})
+}
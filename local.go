@@ -0,0 +1,257 @@
+package libp2pgrpc
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+
+	gostream "github.com/libp2p/go-libp2p-gostream"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// localBufferSize is the buffer size of in-process connections.
+const localBufferSize = 256 * 1024
+
+// localListener hands in-process connections to a Server, skipping
+// libp2p entirely. See WithServer.
+type localListener struct {
+	host      host.Host
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newLocalListener(h host.Host) *localListener {
+	return &localListener{
+		host:  h,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+func (l *localListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *localListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *localListener) Addr() net.Addr {
+	return peerAddr{l.host.ID()}
+}
+
+// dial opens an in-process connection to the server on behalf of the
+// given host, using protocol p.
+func (l *localListener) dial(ctx context.Context, from host.Host, p protocol.ID) (net.Conn, error) {
+	return l.dialAs(ctx, hostAuthInfo(from, p), hostAuthInfo(l.host, p))
+}
+
+// dialAs opens an in-process connection to the server. The server sees
+// the client as client, and the client sees the server as server.
+func (l *localListener) dialAs(ctx context.Context, client, server *AuthInfo) (net.Conn, error) {
+	pipe := bufconn.Listen(localBufferSize)
+	defer pipe.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := pipe.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	clientConn, err := pipe.DialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	serverConn := <-accepted
+
+	select {
+	case l.conns <- &localConn{Conn: serverConn, local: server.ID, info: client}:
+		return &localConn{Conn: clientConn, local: client.ID, info: server}, nil
+	case <-l.done:
+		err = net.ErrClosed
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	clientConn.Close()
+	serverConn.Close()
+	return nil, err
+}
+
+// streamConns keeps an in-process connection to the server for every
+// libp2p stream an http.Server serves, so that the server sees the
+// requests arriving on a stream as coming from the peer on the other
+// end of it. Install connContext and connState on the http.Server.
+type streamConns struct {
+	server *Server
+	// setup, if set, runs once on every streamConn after it is dialed.
+	setup func(sc *streamConn) error
+
+	mu    sync.Mutex
+	conns map[net.Conn]*streamConn
+}
+
+type streamConnKey struct{}
+
+// streamConn is the in-process connection used for the requests
+// arriving on one libp2p stream.
+type streamConn struct {
+	info *AuthInfo
+
+	once    sync.Once
+	cc      *grpc.ClientConn
+	handler http.Handler
+	err     error
+}
+
+func newStreamConns(s *Server, setup func(sc *streamConn) error) *streamConns {
+	return &streamConns{server: s, setup: setup, conns: make(map[net.Conn]*streamConn)}
+}
+
+func (sc *streamConns) connContext(ctx context.Context, c net.Conn) context.Context {
+	stream, ok := c.(network.Stream)
+	if !ok {
+		return ctx
+	}
+
+	conn := &streamConn{info: newAuthInfo(stream)}
+	sc.mu.Lock()
+	sc.conns[c] = conn
+	sc.mu.Unlock()
+	return context.WithValue(ctx, streamConnKey{}, conn)
+}
+
+func (sc *streamConns) connState(c net.Conn, state http.ConnState) {
+	if state != http.StateClosed && state != http.StateHijacked {
+		return
+	}
+
+	sc.mu.Lock()
+	conn := sc.conns[c]
+	delete(sc.conns, c)
+	sc.mu.Unlock()
+
+	if conn != nil {
+		conn.close()
+	}
+}
+
+func (sc *streamConns) close() {
+	sc.mu.Lock()
+	conns := sc.conns
+	sc.conns = make(map[net.Conn]*streamConn)
+	sc.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.close()
+	}
+}
+
+// streamConnFromContext returns the streamConn of the request whose
+// context is ctx.
+func streamConnFromContext(ctx context.Context) (*streamConn, bool) {
+	conn, ok := ctx.Value(streamConnKey{}).(*streamConn)
+	return conn, ok
+}
+
+// dial connects conn to the server the first time it is used.
+func (sc *streamConns) dial(conn *streamConn) error {
+	conn.once.Do(func() {
+		server := hostAuthInfo(sc.server.host, conn.info.Protocol)
+		conn.cc, conn.err = grpc.Dial("passthrough:///"+sc.server.host.ID().String(),
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return sc.server.local.dialAs(ctx, conn.info, server)
+			}),
+			grpc.WithTransportCredentials(NewCredentials()),
+		)
+		if conn.err == nil && sc.setup != nil {
+			if conn.err = sc.setup(conn); conn.err != nil {
+				conn.cc.Close()
+				conn.cc = nil
+			}
+		}
+	})
+	return conn.err
+}
+
+func (conn *streamConn) close() {
+	conn.once.Do(func() {
+		conn.err = net.ErrClosed
+	})
+	if conn.cc != nil {
+		conn.cc.Close()
+	}
+}
+
+// localConn is an in-process connection between a Client and a
+// Server. It carries the AuthInfo of the other end.
+type localConn struct {
+	net.Conn
+
+	local peer.ID
+	info  *AuthInfo
+}
+
+// hostAuthInfo describes h, speaking protocol p, as an in-process peer.
+func hostAuthInfo(h host.Host, p protocol.ID) *AuthInfo {
+	pubKey := h.Peerstore().PubKey(h.ID())
+	if pubKey == nil {
+		pubKey, _ = h.ID().ExtractPublicKey()
+	}
+
+	return &AuthInfo{
+		CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity},
+		ID:             h.ID(),
+		PublicKey:      pubKey,
+		Protocol:       p,
+	}
+}
+
+func (c *localConn) LocalAddr() net.Addr {
+	return peerAddr{c.local}
+}
+
+func (c *localConn) RemoteAddr() net.Addr {
+	return peerAddr{c.info.ID}
+}
+
+// peerAddr is a net.Addr holding a peer ID, like the addresses of
+// gostream connections.
+type peerAddr struct {
+	id peer.ID
+}
+
+func (a peerAddr) Network() string { return gostream.Network }
+
+func (a peerAddr) String() string { return a.id.String() }
+
+// localServer returns the server set through WithServer if the given
+// peer and protocol can be reached in-process.
+func (c *Client) localServer(peerID peer.ID, p protocol.ID) *Server {
+	s := c.server
+	if s == nil || s.host.ID() != peerID {
+		return nil
+	}
+	for _, sp := range s.protocols {
+		if sp == p {
+			return s
+		}
+	}
+	return nil
+}
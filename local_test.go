@@ -0,0 +1,88 @@
+package libp2pgrpc_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+
+	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
+	proto "github.com/drgomesp/go-libp2p-grpc/proto/v1"
+)
+
+func TestWithServerSkipsLibp2p(t *testing.T) {
+	ctx := context.Background()
+
+	// the hosts don't know each other's addresses, so only the
+	// in-process path can reach the server
+	listen, _ := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/0")
+	srvHost := newHost(t, listen)
+	defer srvHost.Close()
+	cliHost := newHost(t, listen)
+	defer cliHost.Close()
+
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost)
+	assert.NoError(t, err)
+	defer srv.Stop()
+	svc := &peerRecordingService{NodeInfoService: NodeInfoService{host: srvHost}}
+	proto.RegisterNodeServiceServer(srv, svc)
+	go srv.Serve()
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID, libp2pgrpc.WithServer(srv))
+	conn, err := client.Dial(ctx, srvHost.ID())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	res, err := proto.NewNodeServiceClient(conn).Info(ctx, &proto.NodeInfoRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, srvHost.ID().String(), res.GetId())
+	assert.Empty(t, srvHost.Network().ConnsToPeer(cliHost.ID()))
+
+	if assert.NotNil(t, svc.peer) {
+		assert.Equal(t, cliHost.ID(), svc.peer.ID)
+		assert.Equal(t, libp2pgrpc.ProtocolID, svc.peer.Protocol)
+	}
+}
+
+func TestWithServerDialSelf(t *testing.T) {
+	ctx := context.Background()
+
+	listen, _ := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/0")
+	h := newHost(t, listen)
+	defer h.Close()
+
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, h)
+	assert.NoError(t, err)
+	defer srv.Stop()
+	proto.RegisterNodeServiceServer(srv, &NodeInfoService{host: h})
+	go srv.Serve()
+
+	client := libp2pgrpc.NewClient(h, libp2pgrpc.ProtocolID, libp2pgrpc.WithServer(srv))
+	conn, err := client.Dial(ctx, h.ID())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	res, err := proto.NewNodeServiceClient(conn).Info(ctx, &proto.NodeInfoRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, h.ID().String(), res.GetId())
+}
+
+func TestWithServerOtherProtocolUsesLibp2p(t *testing.T) {
+	ctx := context.Background()
+	srvHost, cliHost := newConnectedHosts(t)
+
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost)
+	assert.NoError(t, err)
+	defer srv.Stop()
+	proto.RegisterNodeServiceServer(srv, &NodeInfoService{host: srvHost})
+	go srv.Serve()
+
+	client := libp2pgrpc.NewClient(cliHost, "/bad/proto", libp2pgrpc.WithServer(srv))
+	conn, err := client.Dial(ctx, srvHost.ID())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	_, err = proto.NewNodeServiceClient(conn).Info(ctx, &proto.NodeInfoRequest{})
+	assert.ErrorContains(t, err, "protocols not supported")
+}
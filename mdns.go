@@ -0,0 +1,169 @@
+package libp2pgrpc
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+	"github.com/libp2p/zeroconf/v2"
+	"github.com/multiformats/go-multiaddr"
+)
+
+const (
+	mdnsDomain        = "local"
+	mdnsDnsaddrPrefix = "dnsaddr="
+	mdnsPruneInterval = 10 * time.Second
+)
+
+// MDNSServiceTag returns the mDNS service name a Server listening on
+// protocol p announces itself with, e.g. "_libp2p-grpc-1-0-0._udp"
+// for "/libp2p/grpc/1.0.0".
+func MDNSServiceTag(p protocol.ID) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(string(p)) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return "_" + strings.TrimSuffix(b.String(), "-") + "._udp"
+}
+
+// WithMDNS makes the Server announce its host on the local network
+// through mDNS while it serves, under the MDNSServiceTag of every
+// protocol it listens on.
+func WithMDNS() ServerOption {
+	return ServerOption{apply: func(s *Server) {
+		s.mdns = true
+	}}
+}
+
+// startMDNS starts one mDNS service per protocol. The returned
+// function stops them.
+func (s *Server) startMDNS() (func(), error) {
+	services := make([]mdns.Service, 0, len(s.protocols))
+	stop := func() {
+		for _, svc := range services {
+			svc.Close()
+		}
+	}
+
+	for _, p := range s.protocols {
+		svc := mdns.NewMdnsService(s.host, MDNSServiceTag(p), discardNotifee{})
+		if err := svc.Start(); err != nil {
+			stop()
+			return nil, err
+		}
+		services = append(services, svc)
+	}
+	return stop, nil
+}
+
+// discardNotifee ignores the peers found by a server's mDNS service;
+// the server only announces itself.
+type discardNotifee struct{}
+
+func (discardNotifee) HandlePeerFound(peer.AddrInfo) {}
+
+// LocalPeers returns a PeerSource with the peers on the local network
+// that announce the client protocol through mDNS (see WithMDNS). The
+// peers' addresses are added to the peerstore, so they can be dialed
+// with Dial as well as through DialPeers. A peer is dropped when its
+// mDNS record expires without being renewed.
+func (c *Client) LocalPeers() PeerSource {
+	tag := MDNSServiceTag(c.protocol)
+
+	return PeerSourceFunc(func(ctx context.Context) (<-chan []peer.ID, error) {
+		entries := make(chan *zeroconf.ServiceEntry, 1000)
+		go func() {
+			if err := zeroconf.Browse(ctx, tag, mdnsDomain, entries); err != nil {
+				log.Debugw("mDNS browsing failed", "tag", tag, "err", err)
+			}
+		}()
+
+		ch := make(chan []peer.ID)
+		go c.watchLocalPeers(ctx, entries, ch)
+		return ch, nil
+	})
+}
+
+func (c *Client) watchLocalPeers(ctx context.Context, entries <-chan *zeroconf.ServiceEntry, ch chan<- []peer.ID) {
+	defer close(ch)
+
+	ticker := time.NewTicker(mdnsPruneInterval)
+	defer ticker.Stop()
+
+	expiries := make(map[peer.ID]time.Time)
+	var last []peer.ID
+	for {
+		select {
+		case entry, ok := <-entries:
+			if !ok {
+				return
+			}
+			for _, info := range mdnsEntryPeers(entry) {
+				if info.ID == c.host.ID() {
+					continue
+				}
+				c.host.Peerstore().AddAddrs(info.ID, info.Addrs, time.Until(entry.Expiry))
+				expiries[info.ID] = entry.Expiry
+			}
+		case now := <-ticker.C:
+			for id, expiry := range expiries {
+				if now.After(expiry) {
+					delete(expiries, id)
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+
+		ids := make([]peer.ID, 0, len(expiries))
+		for id := range expiries {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		if equalPeerIDs(ids, last) {
+			continue
+		}
+
+		select {
+		case ch <- ids:
+			last = ids
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// mdnsEntryPeers reads the peers from the dnsaddr TXT records of an
+// mDNS entry, the same way the libp2p mDNS service does.
+func mdnsEntryPeers(entry *zeroconf.ServiceEntry) []peer.AddrInfo {
+	addrs := make([]multiaddr.Multiaddr, 0, len(entry.Text))
+	for _, txt := range entry.Text {
+		if !strings.HasPrefix(txt, mdnsDnsaddrPrefix) {
+			continue
+		}
+		addr, err := multiaddr.NewMultiaddr(txt[len(mdnsDnsaddrPrefix):])
+		if err != nil {
+			log.Debugw("failed to parse mDNS address", "err", err)
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+
+	infos, err := peer.AddrInfosFromP2pAddrs(addrs...)
+	if err != nil {
+		log.Debugw("failed to read peers from mDNS entry", "err", err)
+		return nil
+	}
+	return infos
+}
@@ -0,0 +1,71 @@
+package libp2pgrpc_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+
+	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
+)
+
+func TestMDNSServiceTag(t *testing.T) {
+	assert.Equal(t, "_libp2p-grpc-1-0-0._udp", libp2pgrpc.MDNSServiceTag(libp2pgrpc.ProtocolID))
+	assert.Equal(t, "_myapp-grpc-admin-1-0-0._udp", libp2pgrpc.MDNSServiceTag("/myapp/grpc/admin/1.0.0/"))
+}
+
+// hasMulticastInterface reports whether mDNS can work at all in the
+// test environment.
+func hasMulticastInterface() bool {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return false
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagMulticast != 0 && iface.Flags&net.FlagLoopback == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMDNSLocalPeers(t *testing.T) {
+	if !hasMulticastInterface() {
+		t.Skip("no multicast-capable network interface")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	listen, _ := multiaddr.NewMultiaddr("/ip4/0.0.0.0/tcp/0")
+	srvHost := newHost(t, listen)
+	defer srvHost.Close()
+	cliHost := newHost(t, listen)
+	defer cliHost.Close()
+
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost, libp2pgrpc.WithMDNS())
+	assert.NoError(t, err)
+	defer srv.Stop()
+	serveNodeInfo(t, srv, srvHost)
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	updates, err := client.LocalPeers().Peers(ctx)
+	assert.NoError(t, err)
+
+	for found := false; !found; {
+		select {
+		case ids := <-updates:
+			for _, id := range ids {
+				found = found || id == srvHost.ID()
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("server was not found through mDNS")
+		}
+	}
+
+	assert.NotEmpty(t, cliHost.Peerstore().Addrs(srvHost.ID()))
+	assert.NoError(t, callInfo(ctx, cliHost, srvHost))
+}
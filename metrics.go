@@ -0,0 +1,264 @@
+package libp2pgrpc
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/metricshelper"
+	"github.com/multiformats/go-multistream"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricNamespace = "libp2p_grpc"
+
+var (
+	streamsAccepted = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "streams_accepted_total",
+			Help:      "Inbound gRPC streams accepted",
+		},
+		[]string{"protocol"},
+	)
+	negotiationFailures = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "stream_negotiation_failures_total",
+			Help:      "Outbound gRPC streams the peer supported none of the protocols of",
+		},
+		[]string{"protocol"},
+	)
+	dialDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: metricNamespace,
+			Name:      "dial_duration_seconds",
+			Help:      "Time taken to open a gRPC stream to a peer",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+		},
+		[]string{"protocol"},
+	)
+	clientConns = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: metricNamespace,
+			Name:      "client_conns",
+			Help:      "Open gRPC client connections",
+		},
+	)
+	bytesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricNamespace,
+			Name:      "bytes_total",
+			Help:      "Bytes read and written on gRPC streams",
+		},
+		[]string{"protocol", "dir"},
+	)
+	collectors = []prometheus.Collector{
+		streamsAccepted,
+		negotiationFailures,
+		dialDuration,
+		clientConns,
+		bytesTotal,
+	}
+)
+
+// MetricsTracer tracks the metrics of the libp2p streams carrying gRPC
+// connections, the ones accepted by a Server and dialed by a Client.
+type MetricsTracer interface {
+	// StreamAccepted counts an inbound stream accepted by a Server.
+	StreamAccepted(p protocol.ID)
+
+	// NegotiationFailed counts an outbound stream the peer supported
+	// none of the protocols of.
+	NegotiationFailed(p protocol.ID)
+
+	// StreamDialed records the time taken to open a stream to a peer.
+	StreamDialed(p protocol.ID, d time.Duration)
+
+	// ClientConnOpened and ClientConnClosed track the open client
+	// connections, a gRPC connection being open while it has a stream
+	// to its peer.
+	ClientConnOpened()
+	ClientConnClosed()
+
+	// BytesRead and BytesWritten count the bytes of the gRPC streams,
+	// grouped by protocol.
+	BytesRead(p protocol.ID, n int)
+	BytesWritten(p protocol.ID, n int)
+}
+
+type metricsTracer struct{}
+
+var _ MetricsTracer = &metricsTracer{}
+
+type metricsTracerSetting struct {
+	reg prometheus.Registerer
+}
+
+type MetricsTracerOption func(*metricsTracerSetting)
+
+// WithRegisterer registers the metrics on reg instead of
+// prometheus.DefaultRegisterer.
+func WithRegisterer(reg prometheus.Registerer) MetricsTracerOption {
+	return func(s *metricsTracerSetting) {
+		if reg != nil {
+			s.reg = reg
+		}
+	}
+}
+
+// NewMetricsTracer returns a MetricsTracer recording to Prometheus, to
+// be installed with WithServerMetrics and WithClientMetrics. The
+// metrics are named libp2p_grpc_*, alongside the ones of libp2p.
+func NewMetricsTracer(opts ...MetricsTracerOption) MetricsTracer {
+	setting := &metricsTracerSetting{reg: prometheus.DefaultRegisterer}
+	for _, opt := range opts {
+		opt(setting)
+	}
+	metricshelper.RegisterCollectors(setting.reg, collectors...)
+	return &metricsTracer{}
+}
+
+func (m *metricsTracer) StreamAccepted(p protocol.ID) {
+	streamsAccepted.WithLabelValues(string(p)).Inc()
+}
+
+func (m *metricsTracer) NegotiationFailed(p protocol.ID) {
+	negotiationFailures.WithLabelValues(string(p)).Inc()
+}
+
+func (m *metricsTracer) StreamDialed(p protocol.ID, d time.Duration) {
+	dialDuration.WithLabelValues(string(p)).Observe(d.Seconds())
+}
+
+func (m *metricsTracer) ClientConnOpened() {
+	clientConns.Inc()
+}
+
+func (m *metricsTracer) ClientConnClosed() {
+	clientConns.Dec()
+}
+
+func (m *metricsTracer) BytesRead(p protocol.ID, n int) {
+	tags := metricshelper.GetStringSlice()
+	defer metricshelper.PutStringSlice(tags)
+
+	*tags = append(*tags, string(p), "in")
+	bytesTotal.WithLabelValues(*tags...).Add(float64(n))
+}
+
+func (m *metricsTracer) BytesWritten(p protocol.ID, n int) {
+	tags := metricshelper.GetStringSlice()
+	defer metricshelper.PutStringSlice(tags)
+
+	*tags = append(*tags, string(p), "out")
+	bytesTotal.WithLabelValues(*tags...).Add(float64(n))
+}
+
+// WithServerMetrics makes the Server report the streams it accepts,
+// gRPC-Web ones included, to mt.
+func WithServerMetrics(mt MetricsTracer) ServerOption {
+	return ServerOption{apply: func(s *Server) {
+		s.metrics = mt
+	}}
+}
+
+// WithClientMetrics makes the Client report the streams it dials to
+// mt. In-process connections to a server (see WithServer) are not
+// reported.
+func WithClientMetrics(mt MetricsTracer) ClientOption {
+	return func(c *Client) {
+		c.metrics = mt
+	}
+}
+
+// meterListener reports the streams accepted by l to the metrics
+// tracer of the Server, if any.
+func (s *Server) meterListener(l net.Listener) net.Listener {
+	if s.metrics == nil {
+		return l
+	}
+	return &meteredListener{Listener: l, metrics: s.metrics}
+}
+
+type meteredListener struct {
+	net.Listener
+	metrics MetricsTracer
+}
+
+func (l *meteredListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	c, ok := hookConn(conn)
+	if !ok {
+		return conn, nil
+	}
+
+	l.metrics.StreamAccepted(c.Protocol())
+	meterBytes(c, l.metrics, c.Protocol())
+	return c, nil
+}
+
+// meterBytes counts the bytes of c.
+func meterBytes(c *hookedConn, mt MetricsTracer, p protocol.ID) {
+	c.onRead = append(c.onRead, func(n int, _ error) {
+		if n > 0 {
+			mt.BytesRead(p, n)
+		}
+	})
+	c.onWrite = append(c.onWrite, func(n int) {
+		if n > 0 {
+			mt.BytesWritten(p, n)
+		}
+	})
+}
+
+// meterClientConn counts c as a client connection until it is closed,
+// and reports a failed negotiation of its protocol.
+func meterClientConn(c *hookedConn, mt MetricsTracer, p protocol.ID) {
+	mt.ClientConnOpened()
+	c.onClose = append(c.onClose, mt.ClientConnClosed)
+
+	// with lazy negotiation, an unsupported protocol only shows up
+	// when reading the response of the peer.
+	negotiated := false
+	c.onRead = append(c.onRead, func(n int, err error) {
+		if negotiated || (n == 0 && err == nil) {
+			return
+		}
+		negotiated = true
+		if isNegotiationError(err) {
+			mt.NegotiationFailed(p)
+		}
+	})
+}
+
+// meterDial reports a stream dialed by the Client in the given time to
+// its metrics tracer, if any.
+func (c *Client) meterDial(conn net.Conn, p protocol.ID, d time.Duration, err error) net.Conn {
+	if c.metrics == nil {
+		return conn
+	}
+	if err != nil {
+		if isNegotiationError(err) {
+			c.metrics.NegotiationFailed(p)
+		}
+		return conn
+	}
+
+	hooked, ok := hookConn(conn)
+	if !ok {
+		return conn
+	}
+	c.metrics.StreamDialed(p, d)
+	meterBytes(hooked, c.metrics, p)
+	meterClientConn(hooked, c.metrics, p)
+	return hooked
+}
+
+func isNegotiationError(err error) bool {
+	return errors.Is(err, multistream.ErrNotSupported[protocol.ID]{})
+}
@@ -0,0 +1,117 @@
+package libp2pgrpc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+
+	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
+	proto "github.com/drgomesp/go-libp2p-grpc/proto/v1"
+)
+
+// metricValue returns the value of the metric with the given name and
+// labels: its sample count for a histogram.
+func metricValue(t *testing.T, reg *prometheus.Registry, name string, labels map[string]string) float64 {
+	families, err := reg.Gather()
+	assert.NoError(t, err)
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			if !hasLabels(m, labels) {
+				continue
+			}
+			switch {
+			case m.Counter != nil:
+				return m.GetCounter().GetValue()
+			case m.Gauge != nil:
+				return m.GetGauge().GetValue()
+			case m.Histogram != nil:
+				return float64(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return 0
+}
+
+func hasLabels(m *dto.Metric, labels map[string]string) bool {
+	found := 0
+	for _, l := range m.GetLabel() {
+		if v, ok := labels[l.GetName()]; ok && v == l.GetValue() {
+			found++
+		}
+	}
+	return found == len(labels)
+}
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 2)
+	srvHost, cliHost := hosts[0], hosts[1]
+
+	const p = protocol.ID("/metrics/1.0.0")
+	streams := map[string]string{"protocol": string(p)}
+
+	// the metrics are shared by every tracer, so only their changes
+	// are checked
+	reg := prometheus.NewRegistry()
+	mt := libp2pgrpc.NewMetricsTracer(libp2pgrpc.WithRegisterer(reg))
+	accepted := metricValue(t, reg, "libp2p_grpc_streams_accepted_total", streams)
+	dials := metricValue(t, reg, "libp2p_grpc_dial_duration_seconds", streams)
+	conns := metricValue(t, reg, "libp2p_grpc_client_conns", nil)
+
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost, libp2pgrpc.WithProtocol(p), libp2pgrpc.WithServerMetrics(mt))
+	assert.NoError(t, err)
+	defer srv.Stop()
+	serveNodeInfo(t, srv, srvHost)
+
+	conn, err := libp2pgrpc.NewClient(cliHost, p, libp2pgrpc.WithClientMetrics(mt)).Dial(ctx, srvHost.ID())
+	assert.NoError(t, err)
+
+	_, err = proto.NewNodeServiceClient(conn).Info(ctx, &proto.NodeInfoRequest{}, grpc.WaitForReady(true))
+	assert.NoError(t, err)
+
+	assert.Equal(t, accepted+1, metricValue(t, reg, "libp2p_grpc_streams_accepted_total", streams))
+	assert.Equal(t, dials+1, metricValue(t, reg, "libp2p_grpc_dial_duration_seconds", streams))
+	assert.Equal(t, conns+1, metricValue(t, reg, "libp2p_grpc_client_conns", nil))
+	for _, dir := range []string{"in", "out"} {
+		// both the client and the server count their bytes
+		assert.Greater(t, metricValue(t, reg, "libp2p_grpc_bytes_total", map[string]string{"protocol": string(p), "dir": dir}), 0.0)
+	}
+
+	conn.Close()
+	assert.Eventually(t, func() bool {
+		return metricValue(t, reg, "libp2p_grpc_client_conns", nil) == conns
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestMetricsNegotiationFailure(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 2)
+	srvHost, cliHost := hosts[0], hosts[1]
+
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost)
+	assert.NoError(t, err)
+	defer srv.Stop()
+	serveNodeInfo(t, srv, srvHost)
+
+	reg := prometheus.NewRegistry()
+	mt := libp2pgrpc.NewMetricsTracer(libp2pgrpc.WithRegisterer(reg))
+
+	conn, err := libp2pgrpc.NewClient(cliHost, "/metrics/bad/1.0.0", libp2pgrpc.WithClientMetrics(mt)).Dial(ctx, srvHost.ID())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	_, err = proto.NewNodeServiceClient(conn).Info(ctx, &proto.NodeInfoRequest{})
+	assert.Error(t, err)
+	assert.GreaterOrEqual(t, metricValue(t, reg, "libp2p_grpc_stream_negotiation_failures_total",
+		map[string]string{"protocol": "/metrics/bad/1.0.0"}), 1.0)
+}
@@ -0,0 +1,44 @@
+package libp2pgrpc
+
+import (
+	"net"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/stretchr/testify/assert"
+)
+
+// connCounter counts the client connections reported to it.
+type connCounter struct {
+	MetricsTracer
+	open int
+}
+
+func (c *connCounter) ClientConnOpened() { c.open++ }
+func (c *connCounter) ClientConnClosed() { c.open-- }
+
+type nopStream struct {
+	network.Stream
+}
+
+func (nopStream) Reset() error { return nil }
+
+func TestMeteredConnClosedOnce(t *testing.T) {
+	for name, end := range map[string]func(*hookedConn){
+		"close":           func(c *hookedConn) { c.Close() },
+		"reset":           func(c *hookedConn) { c.Reset() },
+		"reset and close": func(c *hookedConn) { c.Reset(); c.Close() },
+		"as a stream":     func(c *hookedConn) { network.Stream(c).Reset() },
+	} {
+		t.Run(name, func(t *testing.T) {
+			counter := &connCounter{}
+			conn, peer := net.Pipe()
+			defer peer.Close()
+			c := &hookedConn{Stream: nopStream{}, conn: conn}
+			meterClientConn(c, counter, ProtocolID)
+			assert.Equal(t, 1, counter.open)
+			end(c)
+			assert.Equal(t, 0, counter.open)
+		})
+	}
+}
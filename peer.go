@@ -0,0 +1,72 @@
+package libp2pgrpc
+
+import (
+	"context"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multiaddr"
+	"google.golang.org/grpc/credentials"
+	grpcpeer "google.golang.org/grpc/peer"
+)
+
+// AuthType is the value returned by AuthInfo.AuthType.
+const AuthType = "libp2p"
+
+var _ credentials.AuthInfo = &AuthInfo{}
+
+// AuthInfo describes the libp2p peer on the other end of a gRPC
+// connection. The identity has already been authenticated by the
+// libp2p security handshake.
+type AuthInfo struct {
+	credentials.CommonAuthInfo
+
+	// ID is the peer ID of the remote peer.
+	ID peer.ID
+	// PublicKey is the public key of the remote peer.
+	PublicKey crypto.PubKey
+	// Protocol is the protocol ID negotiated for the underlying stream.
+	Protocol protocol.ID
+	// Addr is the remote multiaddr of the underlying libp2p connection.
+	Addr multiaddr.Multiaddr
+
+	// admission is set on the streams accepted by a Server, whose calls
+	// it may refuse.
+	admission *admission
+}
+
+// AuthType returns the authentication type, "libp2p".
+func (*AuthInfo) AuthType() string {
+	return AuthType
+}
+
+// PeerFromContext returns the AuthInfo of the libp2p peer that issued
+// the RPC handled with ctx.
+func PeerFromContext(ctx context.Context) (*AuthInfo, bool) {
+	p, ok := grpcpeer.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	info, ok := p.AuthInfo.(*AuthInfo)
+	return info, ok
+}
+
+func newAuthInfo(s network.Stream) *AuthInfo {
+	conn := s.Conn()
+
+	pubKey := conn.RemotePublicKey()
+	if pubKey == nil {
+		pubKey, _ = conn.RemotePeer().ExtractPublicKey()
+	}
+
+	return &AuthInfo{
+		CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: securityLevel(conn)},
+		ID:             conn.RemotePeer(),
+		PublicKey:      pubKey,
+		Protocol:       s.Protocol(),
+		Addr:           conn.RemoteMultiaddr(),
+		admission:      admissionOf(s),
+	}
+}
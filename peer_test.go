@@ -0,0 +1,58 @@
+package libp2pgrpc_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/credentials"
+
+	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
+	proto "github.com/drgomesp/go-libp2p-grpc/proto/v1"
+)
+
+type peerRecordingService struct {
+	NodeInfoService
+
+	peer *libp2pgrpc.AuthInfo
+}
+
+func (s *peerRecordingService) Info(ctx context.Context, req *proto.NodeInfoRequest) (*proto.NodeInfoResponse, error) {
+	s.peer, _ = libp2pgrpc.PeerFromContext(ctx)
+	return s.NodeInfoService.Info(ctx, req)
+}
+
+func TestPeerFromContext(t *testing.T) {
+	ctx := context.Background()
+	srvHost, cliHost := newConnectedHosts(t)
+
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost)
+	assert.NoError(t, err)
+	defer srv.Stop()
+
+	svc := &peerRecordingService{NodeInfoService: NodeInfoService{host: srvHost}}
+	proto.RegisterNodeServiceServer(srv, svc)
+	go srv.Serve()
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	conn, err := client.Dial(ctx, srvHost.ID())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	_, err = proto.NewNodeServiceClient(conn).Info(ctx, &proto.NodeInfoRequest{})
+	assert.NoError(t, err)
+
+	if assert.NotNil(t, svc.peer) {
+		assert.Equal(t, cliHost.ID(), svc.peer.ID)
+		assert.True(t, cliHost.Peerstore().PubKey(cliHost.ID()).Equals(svc.peer.PublicKey))
+		assert.Equal(t, libp2pgrpc.ProtocolID, svc.peer.Protocol)
+		assert.NotNil(t, svc.peer.Addr)
+		assert.Equal(t, credentials.PrivacyAndIntegrity, svc.peer.SecurityLevel)
+		assert.Equal(t, libp2pgrpc.AuthType, svc.peer.AuthType())
+	}
+}
+
+func TestPeerFromContextWithoutPeer(t *testing.T) {
+	_, ok := libp2pgrpc.PeerFromContext(context.Background())
+	assert.False(t, ok)
+}
@@ -0,0 +1,218 @@
+package libp2pgrpc
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// DefaultConnIdleTimeout is how long a connection pooled by
+// Client.Conn may go without RPCs before it is closed.
+const DefaultConnIdleTimeout = 5 * time.Minute
+
+var errClientClosed = errors.New("libp2pgrpc: client closed")
+
+// WithMaxConns caps the number of connections pooled by Client.Conn.
+// Past the cap, the least recently used connections without RPCs in
+// flight are closed. Zero means no cap.
+func WithMaxConns(n int) ClientOption {
+	return func(c *Client) {
+		c.maxConns = n
+	}
+}
+
+// WithConnIdleTimeout sets how long a connection pooled by Client.Conn
+// may go without RPCs before it is closed. Zero disables idle eviction.
+func WithConnIdleTimeout(d time.Duration) ClientOption {
+	return func(c *Client) {
+		c.connIdleTimeout = d
+	}
+}
+
+// Conn returns a connection to the given peer on the client protocol.
+// Connections are pooled: every caller asking for the same peer shares
+// one, so callers must not close it. Pooled connections are closed when
+// idle, when evicted by WithMaxConns, when the peer disconnects and on
+// Close; call Conn for each use rather than holding on to the result.
+func (c *Client) Conn(ctx context.Context, peerID peer.ID) (*grpc.ClientConn, error) {
+	return c.ProtocolConn(ctx, peerID, c.protocol)
+}
+
+// ProtocolConn is like Conn, but speaks protocol p to the peer.
+func (c *Client) ProtocolConn(ctx context.Context, peerID peer.ID, p protocol.ID) (*grpc.ClientConn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.connPool().get(ctx, poolKey{peer: peerID, protocol: p})
+}
+
+// Close closes the connections pooled by Conn and stops following the
+// connectivity events of the host. Connections returned by Dial are
+// not closed.
+func (c *Client) Close() error {
+	// without a pool yet, a closed one is enough to fail later calls
+	c.poolOnce.Do(func() {
+		c.pool = &connPool{cache: &connCache{closed: true}}
+	})
+	c.pool.close()
+
+	c.watcherMu.Lock()
+	if c.watcher != nil {
+		c.watcher.close()
+		c.watcher = nil
+	}
+	c.watcherMu.Unlock()
+	return nil
+}
+
+func (c *Client) connPool() *connPool {
+	c.poolOnce.Do(func() {
+		c.pool = newConnPool(c)
+	})
+	return c.pool
+}
+
+type poolKey struct {
+	peer     peer.ID
+	protocol protocol.ID
+}
+
+type connPool struct {
+	client   *Client
+	notifiee *network.NotifyBundle
+	cache    *connCache
+}
+
+func newConnPool(c *Client) *connPool {
+	p := &connPool{
+		client: c,
+		cache:  newConnCache(c.maxConns, c.connIdleTimeout),
+	}
+
+	p.notifiee = &network.NotifyBundle{
+		DisconnectedF: func(n network.Network, conn network.Conn) {
+			if n.Connectedness(conn.RemotePeer()) != network.Connected {
+				go p.evictPeer(conn.RemotePeer())
+			}
+		},
+	}
+	c.host.Network().Notify(p.notifiee)
+	return p
+}
+
+func (p *connPool) get(ctx context.Context, key poolKey) (*grpc.ClientConn, error) {
+	p.cache.mu.Lock()
+	defer p.cache.mu.Unlock()
+
+	if p.cache.closed {
+		return nil, errClientClosed
+	}
+
+	entry, ok := p.cache.entries[key]
+	if ok && entry.conn.(*grpc.ClientConn).GetState() == connectivity.Shutdown {
+		// closed by a caller after all
+		p.cache.removeLocked(entry)
+		ok = false
+	}
+	if !ok {
+		entry = p.cache.addLocked(key, nil)
+		conn, err := p.dial(ctx, entry)
+		if err != nil {
+			p.cache.removeLocked(entry)
+			return nil, err
+		}
+		entry.conn = conn
+	}
+
+	p.cache.touchLocked(entry)
+	return entry.conn.(*grpc.ClientConn), nil
+}
+
+func (p *connPool) dial(ctx context.Context, entry *cacheEntry) (*grpc.ClientConn, error) {
+	c := p.client
+	key := entry.key.(poolKey)
+
+	target := Target(key.peer)
+	if key.protocol != c.protocol {
+		target += "?protocol=" + url.QueryEscape(string(key.protocol))
+	}
+
+	opts := append(c.DialOptions(ctx),
+		grpc.WithChainUnaryInterceptor(p.unaryInterceptor(entry)),
+		grpc.WithChainStreamInterceptor(p.streamInterceptor(entry)),
+	)
+	return grpc.DialContext(ctx, target, opts...)
+}
+
+func (p *connPool) evictPeer(id peer.ID) {
+	p.cache.mu.Lock()
+	defer p.cache.mu.Unlock()
+
+	for key, entry := range p.cache.entries {
+		if key.(poolKey).peer == id {
+			p.cache.removeLocked(entry)
+		}
+	}
+}
+
+func (p *connPool) close() {
+	if p.cache.close() {
+		p.client.host.Network().StopNotify(p.notifiee)
+	}
+}
+
+func (p *connPool) unaryInterceptor(entry *cacheEntry) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		p.cache.begin(entry)
+		defer p.cache.end(entry)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func (p *connPool) streamInterceptor(entry *cacheEntry) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		p.cache.begin(entry)
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			p.cache.end(entry)
+			return nil, err
+		}
+
+		ps := &pooledStream{ClientStream: stream, desc: desc, done: make(chan struct{})}
+		go func() {
+			select {
+			case <-ps.done:
+			case <-stream.Context().Done():
+			}
+			p.cache.end(entry)
+		}()
+		return ps, nil
+	}
+}
+
+// pooledStream reports when a stream on a pooled connection is over,
+// so the connection counts as in use until then.
+type pooledStream struct {
+	grpc.ClientStream
+
+	desc     *grpc.StreamDesc
+	doneOnce sync.Once
+	done     chan struct{}
+}
+
+func (s *pooledStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	// without server streaming, the stream is over after one message
+	if err != nil || !s.desc.ServerStreams {
+		s.doneOnce.Do(func() { close(s.done) })
+	}
+	return err
+}
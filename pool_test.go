@@ -0,0 +1,125 @@
+package libp2pgrpc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+
+	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
+	proto "github.com/drgomesp/go-libp2p-grpc/proto/v1"
+)
+
+func isShutdown(conn *grpc.ClientConn) func() bool {
+	return func() bool { return conn.GetState() == connectivity.Shutdown }
+}
+
+func TestConnReusesConnections(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 2)
+	cliHost, srvHost := hosts[0], hosts[1]
+	serveReplicas(t, hosts[1:])
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	defer client.Close()
+
+	conn, err := client.Conn(ctx, srvHost.ID())
+	assert.NoError(t, err)
+	res, err := proto.NewNodeServiceClient(conn).Info(ctx, &proto.NodeInfoRequest{}, grpc.WaitForReady(true))
+	assert.NoError(t, err)
+	assert.Equal(t, srvHost.ID().String(), res.GetId())
+
+	again, err := client.Conn(ctx, srvHost.ID())
+	assert.NoError(t, err)
+	assert.Same(t, conn, again)
+
+	other, err := client.ProtocolConn(ctx, srvHost.ID(), protocol.ID("/other/grpc/1.0.0"))
+	assert.NoError(t, err)
+	assert.NotSame(t, conn, other)
+
+	// a pooled connection closed by a caller is replaced
+	conn.Close()
+	replaced, err := client.Conn(ctx, srvHost.ID())
+	assert.NoError(t, err)
+	assert.NotSame(t, conn, replaced)
+}
+
+func TestConnMaxConns(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 4)
+	cliHost, servers := hosts[0], hosts[1:]
+	serveReplicas(t, servers)
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID, libp2pgrpc.WithMaxConns(2))
+	defer client.Close()
+
+	conns := make([]*grpc.ClientConn, len(servers))
+	for i, h := range servers {
+		conn, err := client.Conn(ctx, h.ID())
+		assert.NoError(t, err)
+		conns[i] = conn
+	}
+
+	assert.Equal(t, connectivity.Shutdown, conns[0].GetState())
+	assert.NotEqual(t, connectivity.Shutdown, conns[1].GetState())
+	assert.NotEqual(t, connectivity.Shutdown, conns[2].GetState())
+}
+
+func TestConnIdleTimeout(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 2)
+	cliHost, srvHost := hosts[0], hosts[1]
+	serveReplicas(t, hosts[1:])
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID, libp2pgrpc.WithConnIdleTimeout(100*time.Millisecond))
+	defer client.Close()
+
+	conn, err := client.Conn(ctx, srvHost.ID())
+	assert.NoError(t, err)
+	_, err = proto.NewNodeServiceClient(conn).Info(ctx, &proto.NodeInfoRequest{}, grpc.WaitForReady(true))
+	assert.NoError(t, err)
+
+	assert.Eventually(t, isShutdown(conn), 2*time.Second, 10*time.Millisecond)
+
+	fresh, err := client.Conn(ctx, srvHost.ID())
+	assert.NoError(t, err)
+	assert.NotSame(t, conn, fresh)
+}
+
+func TestConnEvictedOnDisconnect(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 2)
+	cliHost, srvHost := hosts[0], hosts[1]
+	serveReplicas(t, hosts[1:])
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	defer client.Close()
+
+	conn, err := client.Conn(ctx, srvHost.ID())
+	assert.NoError(t, err)
+	_, err = proto.NewNodeServiceClient(conn).Info(ctx, &proto.NodeInfoRequest{}, grpc.WaitForReady(true))
+	assert.NoError(t, err)
+
+	assert.NoError(t, cliHost.Network().ClosePeer(srvHost.ID()))
+	assert.Eventually(t, isShutdown(conn), 2*time.Second, 10*time.Millisecond)
+}
+
+func TestClientClose(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 2)
+	cliHost, srvHost := hosts[0], hosts[1]
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	conn, err := client.Conn(ctx, srvHost.ID())
+	assert.NoError(t, err)
+
+	assert.NoError(t, client.Close())
+	assert.Equal(t, connectivity.Shutdown, conn.GetState())
+
+	_, err = client.Conn(ctx, srvHost.ID())
+	assert.Error(t, err)
+}
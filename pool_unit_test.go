@@ -0,0 +1,53 @@
+package libp2pgrpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/connectivity"
+)
+
+func TestConnPoolKeepsBusyConns(t *testing.T) {
+	ctx := context.Background()
+	h, err := libp2p.New(libp2p.NoListenAddrs)
+	assert.NoError(t, err)
+	defer h.Close()
+
+	c := NewClient(h, ProtocolID, WithMaxConns(1))
+	defer c.Close()
+
+	idA, idB := test.RandPeerIDFatal(t), test.RandPeerIDFatal(t)
+
+	a, err := c.Conn(ctx, idA)
+	assert.NoError(t, err)
+	entry := c.pool.cache.entries[poolKey{peer: idA, protocol: ProtocolID}]
+	c.pool.cache.begin(entry)
+
+	_, err = c.Conn(ctx, idB)
+	assert.NoError(t, err)
+	assert.NotEqual(t, connectivity.Shutdown, a.GetState())
+	assert.Len(t, c.pool.cache.entries, 2)
+
+	// once a is done, it is evicted
+	c.pool.cache.end(entry)
+	assert.Equal(t, connectivity.Shutdown, a.GetState())
+	assert.Len(t, c.pool.cache.entries, 1)
+}
+
+func TestCloseWithoutPool(t *testing.T) {
+	h, err := libp2p.New(libp2p.NoListenAddrs)
+	assert.NoError(t, err)
+	defer h.Close()
+
+	c := NewClient(h, ProtocolID, WithConnIdleTimeout(time.Millisecond))
+	assert.NoError(t, c.Close())
+	assert.Nil(t, c.pool.notifiee)
+	assert.Nil(t, c.pool.cache.done)
+
+	_, err = c.Conn(context.Background(), test.RandPeerIDFatal(t))
+	assert.Equal(t, errClientClosed, err)
+}
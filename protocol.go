@@ -1,5 +1,10 @@
 package libp2pgrpc
 
-import "github.com/libp2p/go-libp2p/core/protocol"
+import (
+	logging "github.com/ipfs/go-log/v2"
+	"github.com/libp2p/go-libp2p/core/protocol"
+)
+
+var log = logging.Logger("libp2pgrpc")
 
 var ProtocolID protocol.ID = "/libp2p/grpc/1.0.0"
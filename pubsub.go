@@ -0,0 +1,57 @@
+package libp2pgrpc
+
+import (
+	"context"
+	"sort"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// TopicPeers returns a PeerSource with the peers subscribed to a
+// pubsub topic, as known to the local router. It sends the peers again
+// every time the set changes as peers join or leave. The result can be
+// passed to FanOut to call every subscriber, or to DialPeers:
+//
+//	topic, err := ps.Join("cluster")
+//	results, err := client.FanOut(ctx, libp2pgrpc.TopicPeers(topic), call)
+func TopicPeers(topic *pubsub.Topic) PeerSource {
+	return PeerSourceFunc(func(ctx context.Context) (<-chan []peer.ID, error) {
+		events, err := topic.EventHandler()
+		if err != nil {
+			return nil, err
+		}
+
+		last := topicPeers(topic)
+		ch := make(chan []peer.ID, 1)
+		ch <- last
+		go func() {
+			defer close(ch)
+			defer events.Cancel()
+
+			for {
+				// the handler first replays a join for every current peer
+				if _, err := events.NextPeerEvent(ctx); err != nil {
+					return
+				}
+				ids := topicPeers(topic)
+				if equalPeerIDs(ids, last) {
+					continue
+				}
+				last = ids
+				select {
+				case ch <- ids:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+		return ch, nil
+	})
+}
+
+func topicPeers(topic *pubsub.Topic) []peer.ID {
+	ids := topic.ListPeers()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
@@ -0,0 +1,309 @@
+package libp2pgrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	grpcpeer "google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// RelayPolicy tells whether a Client may reach peers through circuit
+// relay connections.
+type RelayPolicy int
+
+const (
+	// RelayAllowed allows relayed connections, unless they are
+	// transient. This is the default, and the default of libp2p.
+	RelayAllowed RelayPolicy = iota
+	// RelayTransientAllowed also allows transient connections, such as
+	// the ones through a relay limiting their duration and data. The
+	// server must accept them too (see WithTransientConns).
+	RelayTransientAllowed
+	// RelayForbidden only allows direct connections.
+	RelayForbidden
+)
+
+// WithRelayPolicy sets the relay policy of the streams dialed by the
+// Client. It defaults to RelayAllowed.
+func WithRelayPolicy(p RelayPolicy) ClientOption {
+	return func(c *Client) {
+		c.relayPolicy = p
+	}
+}
+
+// RelayOption overrides the relay policy of the Client. It is both a
+// grpc.DialOption, applying to the connection dialed by Client.Dial or
+// Client.DialPeers, and a grpc.CallOption, applying to a single RPC on
+// a StreamConn.
+type RelayOption struct {
+	grpc.EmptyDialOption
+	grpc.EmptyCallOption
+	Policy RelayPolicy
+}
+
+// UseRelayPolicy returns a RelayOption with the given policy.
+func UseRelayPolicy(p RelayPolicy) RelayOption {
+	return RelayOption{Policy: p}
+}
+
+// WithTransientConns makes the Server accept streams over transient
+// connections, as dialed by clients with RelayTransientAllowed. Such
+// connections may be closed soon, or once some data went through, so
+// they only suit short RPCs. They are refused by default: their RPCs
+// fail with a RelayedConnError.
+func WithTransientConns() ServerOption {
+	return ServerOption{apply: func(s *Server) {
+		s.limits.allowTransient = true
+	}}
+}
+
+// ReasonTransientConn is the ErrorInfo reason attached to the errors
+// of RPCs refused by a Server because they came over a transient
+// connection.
+const ReasonTransientConn = "TRANSIENT_CONN"
+
+// transientConnError is the error of the RPCs the server self refuses
+// because they came over a transient connection.
+func transientConnError(self peer.ID) error {
+	relayErr := &RelayedConnError{Peer: self, Transient: true}
+	st, err := status.New(codes.Unavailable, relayErr.Error()).WithDetails(&errdetails.ErrorInfo{
+		Reason:   ReasonTransientConn,
+		Domain:   AuthType,
+		Metadata: map[string]string{"peer_id": self.String()},
+	})
+	if err != nil {
+		return relayErr.GRPCStatus().Err()
+	}
+	return st.Err()
+}
+
+// transientRefusal returns the RelayedConnError of st, if it is the
+// status of an RPC refused by the server over a transient connection.
+func transientRefusal(st *status.Status) (*RelayedConnError, bool) {
+	for _, d := range st.Details() {
+		info, ok := d.(*errdetails.ErrorInfo)
+		if !ok || info.GetReason() != ReasonTransientConn {
+			continue
+		}
+		id, err := peer.Decode(info.GetMetadata()["peer_id"])
+		if err != nil {
+			return nil, false
+		}
+		return &RelayedConnError{Peer: id, Transient: true, status: st}, true
+	}
+	return nil, false
+}
+
+// RelayedConnError is the error of dials refused by the relay policy:
+// the peer could only be reached through a relay. RPCs failing because
+// of it return it as well, with the Unavailable status code, as do the
+// RPCs a server refuses over a transient connection:
+//
+//	var relayErr *libp2pgrpc.RelayedConnError
+//	if errors.As(err, &relayErr) {
+//		// retry with UseRelayPolicy(libp2pgrpc.RelayTransientAllowed)
+//	}
+type RelayedConnError struct {
+	// Peer is the peer that was dialed.
+	Peer peer.ID
+	// Transient tells whether the relayed connection was transient.
+	Transient bool
+
+	status *status.Status
+}
+
+func (e *RelayedConnError) Error() string {
+	if e.Transient {
+		return fmt.Sprintf("libp2pgrpc: peer %s is only reachable through a transient relay connection", e.Peer)
+	}
+	return fmt.Sprintf("libp2pgrpc: peer %s is only reachable through a relay connection", e.Peer)
+}
+
+// Unwrap returns network.ErrTransientConn for transient connections.
+func (e *RelayedConnError) Unwrap() error {
+	if e.Transient {
+		return network.ErrTransientConn
+	}
+	return nil
+}
+
+// GRPCStatus returns the status of the RPC that failed because of e.
+func (e *RelayedConnError) GRPCStatus() *status.Status {
+	if e.status == nil {
+		return status.New(codes.Unavailable, e.Error())
+	}
+	return e.status
+}
+
+// dialRelayPolicy returns the relay policy set by opts, if any, or the
+// one of the Client.
+func (c *Client) dialRelayPolicy(opts []grpc.DialOption) RelayPolicy {
+	p := c.relayPolicy
+	for _, opt := range opts {
+		if o, ok := opt.(RelayOption); ok {
+			p = o.Policy
+		}
+	}
+	return p
+}
+
+// relayContext prepares the dial of ctx for the policy: it may use
+// transient connections if the policy allows them, and it dials the
+// peer directly rather than reuse a relayed connection if the policy
+// forbids relays.
+func relayContext(ctx context.Context, p RelayPolicy) context.Context {
+	switch p {
+	case RelayTransientAllowed:
+		return network.WithUseTransient(ctx, "libp2p-grpc")
+	case RelayForbidden:
+		return network.WithForceDirectDial(ctx, "libp2p-grpc")
+	}
+	return ctx
+}
+
+// checkRelay applies the policy to the outcome of opening a stream to
+// id, returning a RelayedConnError if the peer could only be reached
+// through a relay. A refused stream is reset.
+func (c *Client) checkRelay(id peer.ID, p RelayPolicy, stream network.Stream, err error) error {
+	if err != nil {
+		if errors.Is(err, network.ErrTransientConn) {
+			return &RelayedConnError{Peer: id, Transient: true}
+		}
+		if p == RelayForbidden {
+			// the direct dial failed, but the peer may still be
+			// connected through a relay
+			for _, conn := range c.host.Network().ConnsToPeer(id) {
+				if isRelayed(conn) {
+					return &RelayedConnError{Peer: id, Transient: conn.Stat().Transient}
+				}
+			}
+		}
+		return err
+	}
+
+	if stream != nil && p == RelayForbidden && isRelayed(stream.Conn()) {
+		stream.Reset()
+		return &RelayedConnError{Peer: id, Transient: stream.Conn().Stat().Transient}
+	}
+	return nil
+}
+
+func isRelayed(conn network.Conn) bool {
+	_, err := conn.RemoteMultiaddr().ValueForProtocol(multiaddr.P_CIRCUIT)
+	return err == nil
+}
+
+// dialErrors records the outcome of the dials of one ClientConn, so
+// that its RPCs failing for lack of a connection can tell whether the
+// relay policy is the reason.
+type dialErrors struct {
+	mu        sync.Mutex
+	relayErrs map[peer.ID]*RelayedConnError
+	// last is the peer of the last dial. If its connection fails, its
+	// error is the one gRPC reports, whether the dial failed or the
+	// stream failed right after it.
+	last peer.ID
+}
+
+func newDialErrors() *dialErrors {
+	return &dialErrors{relayErrs: make(map[peer.ID]*RelayedConnError)}
+}
+
+// record records the outcome of a dial to id.
+func (d *dialErrors) record(id peer.ID, err error) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.last = id
+	var relayErr *RelayedConnError
+	if errors.As(err, &relayErr) {
+		d.relayErrs[id] = relayErr
+	} else {
+		delete(d.relayErrs, id)
+	}
+}
+
+// lastRelayError returns the error of the last dial, if the relay
+// policy refused it.
+func (d *dialErrors) lastRelayError() *RelayedConnError {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.relayErrs[d.last]
+}
+
+// relayErrorInterceptors turn the errors of RPCs that failed because of
+// the relay policy into RelayedConnErrors, looking up the dials of the
+// ClientConn in errs.
+func relayErrorInterceptors(errs *dialErrors) []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			var p grpcpeer.Peer
+			err := invoker(ctx, method, req, reply, cc, append(opts, grpc.Peer(&p))...)
+			return relayError(err, &p, errs)
+		}),
+		grpc.WithChainStreamInterceptor(func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			p := &grpcpeer.Peer{}
+			stream, err := streamer(ctx, desc, cc, method, append(opts, grpc.Peer(p))...)
+			if err != nil {
+				return nil, relayError(err, p, errs)
+			}
+			return &relayErrorStream{ClientStream: stream, peer: p, errs: errs}, nil
+		}),
+	}
+}
+
+// relayError returns the RelayedConnError of the last dial of the
+// ClientConn if err is the Unavailable status of an RPC that found
+// no connection to a peer, p being the peer the RPC went to, and the
+// relay policy refused that dial.
+func relayError(err error, p *grpcpeer.Peer, errs *dialErrors) error {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.Unavailable {
+		return err
+	}
+	if relayErr, ok := transientRefusal(st); ok {
+		return relayErr
+	}
+	// the RPC reached a peer, which returned the status
+	if p.Addr != nil {
+		return err
+	}
+
+	if relayErr := errs.lastRelayError(); relayErr != nil {
+		return &RelayedConnError{Peer: relayErr.Peer, Transient: relayErr.Transient, status: st}
+	}
+	return err
+}
+
+type relayErrorStream struct {
+	grpc.ClientStream
+	peer *grpcpeer.Peer
+	errs *dialErrors
+}
+
+func (s *relayErrorStream) Header() (metadata.MD, error) {
+	md, err := s.ClientStream.Header()
+	return md, relayError(err, s.peer, s.errs)
+}
+
+func (s *relayErrorStream) CloseSend() error {
+	return relayError(s.ClientStream.CloseSend(), s.peer, s.errs)
+}
+
+func (s *relayErrorStream) RecvMsg(m interface{}) error {
+	return relayError(s.ClientStream.RecvMsg(m), s.peer, s.errs)
+}
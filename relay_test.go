@@ -0,0 +1,253 @@
+package libp2pgrpc_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p"
+	p2phttp "github.com/libp2p/go-libp2p-http"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	relayclient "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/client"
+	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/status"
+
+	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
+	proto "github.com/drgomesp/go-libp2p-grpc/proto/v1"
+)
+
+// newRelayedHosts returns a server host and a client host that can only
+// reach the server through a circuit relay: the server only listens
+// for relayed connections.
+func newRelayedHosts(t *testing.T, opts ...relayv2.Option) (host.Host, host.Host) {
+	return relayHosts(t, false, opts...)
+}
+
+// relayHosts returns a server host and a client host that only knows
+// the circuit relay address of the server. If listen is set, the
+// server also listens on TCP, so the client may learn its direct
+// addresses.
+func relayHosts(t *testing.T, listen bool, opts ...relayv2.Option) (host.Host, host.Host) {
+	ctx := context.Background()
+	addr := multiaddr.StringCast("/ip4/127.0.0.1/tcp/0")
+
+	relayHost, cliHost := newHost(t, addr), newHost(t, addr)
+	t.Cleanup(func() { relayHost.Close() })
+	t.Cleanup(func() { cliHost.Close() })
+
+	var srvHost host.Host
+	if listen {
+		srvHost = newHost(t, addr)
+	} else {
+		h, err := libp2p.New(libp2p.ListenAddrStrings("/p2p-circuit"))
+		assert.NoError(t, err)
+		srvHost = h
+	}
+	t.Cleanup(func() { srvHost.Close() })
+
+	_, err := relayv2.New(relayHost, opts...)
+	assert.NoError(t, err)
+
+	relayInfo := peer.AddrInfo{ID: relayHost.ID(), Addrs: relayHost.Addrs()}
+	assert.NoError(t, srvHost.Connect(ctx, relayInfo))
+	_, err = relayclient.Reserve(ctx, srvHost, relayInfo)
+	assert.NoError(t, err)
+
+	circuit := multiaddr.StringCast("/p2p/" + relayHost.ID().String() + "/p2p-circuit")
+	cliHost.Peerstore().AddAddrs(srvHost.ID(), []multiaddr.Multiaddr{relayHost.Addrs()[0].Encapsulate(circuit)}, peerstore.PermanentAddrTTL)
+	return srvHost, cliHost
+}
+
+func assertRelayedConnError(t *testing.T, err error, id peer.ID, transient bool) {
+	var relayErr *libp2pgrpc.RelayedConnError
+	if assert.True(t, errors.As(err, &relayErr), "unexpected error %v", err) {
+		assert.Equal(t, id, relayErr.Peer)
+		assert.Equal(t, transient, relayErr.Transient)
+	}
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Equal(t, transient, errors.Is(err, network.ErrTransientConn))
+}
+
+func TestRelayTransientRefused(t *testing.T) {
+	srvHost, cliHost := newRelayedHosts(t)
+	serveLimited(t, srvHost, libp2pgrpc.WithTransientConns())
+
+	conn := dialNode(t, cliHost, srvHost)
+	_, err := proto.NewNodeServiceClient(conn).Info(context.Background(), &proto.NodeInfoRequest{})
+	assertRelayedConnError(t, err, srvHost.ID(), true)
+
+	// a streaming call fails the same way
+	stream, err := testpb.NewTestServiceClient(conn).FullDuplexCall(context.Background())
+	if err == nil {
+		_, err = stream.Header()
+	}
+	assertRelayedConnError(t, err, srvHost.ID(), true)
+
+	// and so does a call on a connection to a set of peers
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	defer client.Close()
+	peers, err := client.DialPeers(context.Background(), libp2pgrpc.StaticPeers(srvHost.ID()))
+	assert.NoError(t, err)
+	defer peers.Close()
+	assert.Eventually(t, func() bool {
+		_, err = proto.NewNodeServiceClient(peers).Info(context.Background(), &proto.NodeInfoRequest{})
+		var relayErr *libp2pgrpc.RelayedConnError
+		return errors.As(err, &relayErr)
+	}, 2*time.Second, 10*time.Millisecond)
+	assertRelayedConnError(t, err, srvHost.ID(), true)
+}
+
+func TestRelayTransientRefusedByGateway(t *testing.T) {
+	ctx := context.Background()
+	srvHost, cliHost := newRelayedHosts(t)
+
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost, libp2pgrpc.WithHTTPGateway(proto.RegisterNodeServiceHandler))
+	assert.NoError(t, err)
+	t.Cleanup(srv.Stop)
+	proto.RegisterNodeServiceServer(srv, &NodeInfoService{host: srvHost})
+	go srv.Serve()
+
+	tr := &http.Transport{}
+	tr.RegisterProtocol("libp2p", p2phttp.NewTransport(cliHost))
+	httpClient := &http.Client{Transport: tr}
+
+	var res *http.Response
+	assert.Eventually(t, func() bool {
+		req, err := http.NewRequestWithContext(network.WithUseTransient(ctx, "test"), http.MethodGet,
+			"libp2p://"+srvHost.ID().String()+"/v1/node/info", nil)
+		assert.NoError(t, err)
+		res, err = httpClient.Do(req)
+		if err != nil {
+			return false
+		}
+		res.Body.Close()
+		return true
+	}, 2*time.Second, 10*time.Millisecond)
+	if res != nil {
+		assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	}
+}
+
+func TestRelayErrorOfDialPeers(t *testing.T) {
+	ctx := context.Background()
+	relayedHost, cliHost := newRelayedHosts(t)
+	serveReplicas(t, []host.Host{relayedHost})
+	directHost := newHost(t, multiaddr.StringCast("/ip4/127.0.0.1/tcp/0"))
+	direct, err := libp2pgrpc.NewGrpcServer(ctx, directHost)
+	assert.NoError(t, err)
+	t.Cleanup(direct.Stop)
+	testpb.RegisterTestServiceServer(direct, grpcWebTestService{})
+	serveNodeInfo(t, direct, directHost)
+	cliHost.Peerstore().AddAddrs(directHost.ID(), directHost.Addrs(), peerstore.PermanentAddrTTL)
+
+	// connected through the relay beforehand, so that the dial to the
+	// relayed peer fails right away, before the first RPC
+	assert.NoError(t, cliHost.Connect(network.WithUseTransient(ctx, "test"), peer.AddrInfo{ID: relayedHost.ID()}))
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	defer client.Close()
+	conn, err := client.DialPeers(ctx, libp2pgrpc.StaticPeers(relayedHost.ID(), directHost.ID()), slowBackoff)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// the relayed peer is refused by the relay policy, while the direct
+	// one answers: its Unavailable status is its own
+	_, err = testpb.NewTestServiceClient(conn).UnaryCall(ctx, &testpb.SimpleRequest{
+		ResponseStatus: &testpb.EchoStatus{Code: int32(codes.Unavailable), Message: "busy"},
+	}, grpc.WaitForReady(true))
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.False(t, errors.As(err, new(*libp2pgrpc.RelayedConnError)), "unexpected error %v", err)
+
+	// once the direct peer fails too, the last failed connection is not
+	// the one the relay policy refused
+	direct.Stop()
+	assert.Eventually(t, func() bool {
+		_, err = proto.NewNodeServiceClient(conn).Info(ctx, &proto.NodeInfoRequest{})
+		return status.Code(err) == codes.Unavailable
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.False(t, errors.As(err, new(*libp2pgrpc.RelayedConnError)), "unexpected error %v", err)
+}
+
+func TestRelayTransientAllowed(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("accepted", func(t *testing.T) {
+		srvHost, cliHost := newRelayedHosts(t)
+		serveLimited(t, srvHost, libp2pgrpc.WithTransientConns())
+
+		client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID, libp2pgrpc.WithRelayPolicy(libp2pgrpc.RelayTransientAllowed))
+		conn, err := client.Dial(ctx, srvHost.ID())
+		assert.NoError(t, err)
+		defer conn.Close()
+		assert.NoError(t, callNode(conn))
+	})
+
+	t.Run("refused by the server", func(t *testing.T) {
+		srvHost, cliHost := newRelayedHosts(t)
+		serveLimited(t, srvHost)
+
+		client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+		conn, err := client.Dial(ctx, srvHost.ID(), libp2pgrpc.UseRelayPolicy(libp2pgrpc.RelayTransientAllowed))
+		assert.NoError(t, err)
+		defer conn.Close()
+		_, err = proto.NewNodeServiceClient(conn).Info(ctx, &proto.NodeInfoRequest{}, grpc.WaitForReady(true))
+		assertRelayedConnError(t, err, srvHost.ID(), true)
+	})
+
+	t.Run("refused by the stream transport", func(t *testing.T) {
+		srvHost, cliHost := newRelayedHosts(t)
+		serveStreamTransport(t, srvHost)
+
+		client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID, libp2pgrpc.WithRelayPolicy(libp2pgrpc.RelayTransientAllowed))
+		_, err := proto.NewNodeServiceClient(client.DialStreams(srvHost.ID())).Info(ctx, &proto.NodeInfoRequest{})
+		assertRelayedConnError(t, err, srvHost.ID(), true)
+	})
+}
+
+func TestRelayForbidden(t *testing.T) {
+	ctx := context.Background()
+	srvHost, cliHost := newRelayedHosts(t, relayv2.WithInfiniteLimits())
+	serveStreamTransport(t, srvHost)
+
+	// unlimited relayed connections are allowed by default
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	assert.NoError(t, callNode(dialNode(t, cliHost, srvHost)))
+
+	conn, err := client.Dial(ctx, srvHost.ID(), libp2pgrpc.UseRelayPolicy(libp2pgrpc.RelayForbidden))
+	assert.NoError(t, err)
+	defer conn.Close()
+	_, err = proto.NewNodeServiceClient(conn).Info(ctx, &proto.NodeInfoRequest{})
+	assertRelayedConnError(t, err, srvHost.ID(), false)
+
+	streams := proto.NewNodeServiceClient(client.DialStreams(srvHost.ID()))
+	_, err = streams.Info(ctx, &proto.NodeInfoRequest{})
+	assert.NoError(t, err)
+	_, err = streams.Info(ctx, &proto.NodeInfoRequest{}, libp2pgrpc.UseRelayPolicy(libp2pgrpc.RelayForbidden))
+	assertRelayedConnError(t, err, srvHost.ID(), false)
+}
+
+func TestRelayForbiddenDialsDirectly(t *testing.T) {
+	ctx := context.Background()
+	srvHost, cliHost := relayHosts(t, true, relayv2.WithInfiniteLimits())
+	serveLimited(t, srvHost)
+
+	// the client is connected through the relay, and learns the direct
+	// addresses of the server
+	assert.True(t, infoRelayed(t, dialNode(t, cliHost, srvHost)))
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	conn, err := client.Dial(ctx, srvHost.ID(), libp2pgrpc.UseRelayPolicy(libp2pgrpc.RelayForbidden))
+	assert.NoError(t, err)
+	defer conn.Close()
+	assert.False(t, infoRelayed(t, conn))
+}
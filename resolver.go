@@ -0,0 +1,159 @@
+package libp2pgrpc
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/multiformats/go-multiaddr"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/resolver"
+)
+
+// Scheme is the gRPC target scheme handled by the Client resolver.
+// Targets take one of the forms
+//
+//	libp2p:///<peerID>
+//	libp2p:///p2p/<peerID>
+//	libp2p:///ip4/127.0.0.1/tcp/10000/p2p/<peerID>
+//
+// and may override the client protocol with a protocol query
+// parameter, e.g. libp2p:///<peerID>?protocol=/myapp/grpc/1.0.0.
+const Scheme = "libp2p"
+
+// Target returns the gRPC target that resolves to the given peer.
+func Target(peerID peer.ID) string {
+	return Scheme + ":///" + peerID.String()
+}
+
+type protocolAttrKey struct{}
+
+// ProtocolFromAddress returns the protocol ID a resolved address must
+// be dialed with, if the resolver attached one.
+func ProtocolFromAddress(addr resolver.Address) (protocol.ID, bool) {
+	return protocolFromAttributes(addr.Attributes)
+}
+
+func protocolFromAttributes(attrs *attributes.Attributes) (protocol.ID, bool) {
+	p, ok := attrs.Value(protocolAttrKey{}).(protocol.ID)
+	return p, ok
+}
+
+var _ resolver.Builder = &resolverBuilder{}
+
+type resolverBuilder struct {
+	client *Client
+}
+
+// Resolver returns a resolver.Builder for the libp2p scheme. The peer
+// addresses found in the target are added to the host's peerstore.
+func (c *Client) Resolver() resolver.Builder {
+	return &resolverBuilder{client: c}
+}
+
+func (b *resolverBuilder) Scheme() string {
+	return Scheme
+}
+
+func (b *resolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	info, err := parseEndpoint(target.Endpoint())
+	if err != nil {
+		return nil, err
+	}
+	if len(info.Addrs) > 0 {
+		b.client.host.Peerstore().AddAddrs(info.ID, info.Addrs, peerstore.AddressTTL)
+	}
+
+	proto := b.client.protocol
+	if p := target.URL.Query().Get("protocol"); p != "" {
+		proto = protocol.ID(p)
+	}
+
+	r := &peerResolver{client: b.client, cc: cc, peerID: info.ID, protocol: proto, streams: newDialedStreams()}
+	if err := r.update(); err != nil {
+		return nil, err
+	}
+	b.client.watchUpgrades(r)
+	return r, nil
+}
+
+// parseEndpoint accepts a bare peer ID or a multiaddr ending in
+// /p2p/<peerID>, with the leading "/" already stripped.
+func parseEndpoint(endpoint string) (*peer.AddrInfo, error) {
+	if !strings.Contains(endpoint, "/") {
+		id, err := peer.Decode(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("libp2pgrpc: invalid peer ID %q: %w", endpoint, err)
+		}
+		return &peer.AddrInfo{ID: id}, nil
+	}
+
+	maddr, err := multiaddr.NewMultiaddr("/" + endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("libp2pgrpc: invalid multiaddr %q: %w", endpoint, err)
+	}
+	return peer.AddrInfoFromP2pAddr(maddr)
+}
+
+// generationAttrKey tells apart the addresses resolved before and after
+// a reconnect.
+type generationAttrKey struct{}
+
+type peerResolver struct {
+	client   *Client
+	cc       resolver.ClientConn
+	peerID   peer.ID
+	protocol protocol.ID
+	streams  *dialedStreams
+
+	mu         sync.Mutex
+	generation int
+}
+
+func (r *peerResolver) update() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.updateLocked()
+}
+
+// updateLocked sends the resolved address to gRPC. Holding r.mu keeps
+// the updates of concurrent reconnects in generation order.
+func (r *peerResolver) updateLocked() error {
+	attrs := attributes.New(protocolAttrKey{}, r.protocol).WithValue(dialedStreamsAttrKey{}, r.streams)
+	if r.generation > 0 {
+		attrs = attrs.WithValue(generationAttrKey{}, r.generation)
+	}
+	return r.cc.UpdateState(resolver.State{
+		Addresses: []resolver.Address{{
+			Addr:       r.peerID.String(),
+			Attributes: attrs,
+		}},
+	})
+}
+
+func (r *peerResolver) relayed(id peer.ID) bool {
+	return id == r.peerID && r.streams.relayed(id)
+}
+
+// reconnect makes gRPC move the connection to a new stream. The
+// resolved address changes, so gRPC dials it again, sends the new RPCs
+// on the new stream and closes the old one once its RPCs are done.
+func (r *peerResolver) reconnect(peer.ID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.generation++
+	r.updateLocked()
+}
+
+func (r *peerResolver) ResolveNow(resolver.ResolveNowOptions) {
+	r.update()
+}
+
+func (r *peerResolver) Close() {
+	r.client.unwatchUpgrades(r)
+}
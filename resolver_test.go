@@ -0,0 +1,82 @@
+package libp2pgrpc_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+
+	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
+	proto "github.com/drgomesp/go-libp2p-grpc/proto/v1"
+)
+
+func TestResolverTargets(t *testing.T) {
+	ctx := context.Background()
+	srvHost, cliHost := newConnectedHosts(t)
+
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost, libp2pgrpc.WithProtocols(libp2pgrpc.ProtocolID, "/myapp/grpc/1.0.0"))
+	assert.NoError(t, err)
+	defer srv.Stop()
+	proto.RegisterNodeServiceServer(srv, &NodeInfoService{host: srvHost})
+	go srv.Serve()
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	targets := []string{
+		libp2pgrpc.Target(srvHost.ID()),
+		"libp2p:///p2p/" + srvHost.ID().String(),
+		"libp2p:///" + srvHost.ID().String() + "?protocol=/myapp/grpc/1.0.0",
+	}
+	for _, target := range targets {
+		conn, err := grpc.Dial(target, client.DialOptions(ctx)...)
+		assert.NoError(t, err, target)
+
+		res, err := proto.NewNodeServiceClient(conn).Info(ctx, &proto.NodeInfoRequest{})
+		assert.NoError(t, err, target)
+		assert.Equal(t, srvHost.ID().String(), res.GetId())
+		conn.Close()
+	}
+}
+
+func TestResolverMultiaddrTarget(t *testing.T) {
+	ctx := context.Background()
+
+	m1, _ := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/10000")
+	m2, _ := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/10001")
+
+	srvHost := newHost(t, m1)
+	defer srvHost.Close()
+
+	cliHost := newHost(t, m2)
+	defer cliHost.Close()
+
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost)
+	assert.NoError(t, err)
+	defer srv.Stop()
+	proto.RegisterNodeServiceServer(srv, &NodeInfoService{host: srvHost})
+	go srv.Serve()
+
+	addrs, err := peer.AddrInfoToP2pAddrs(&peer.AddrInfo{ID: srvHost.ID(), Addrs: srvHost.Addrs()})
+	assert.NoError(t, err)
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	conn, err := grpc.Dial("libp2p://"+addrs[0].String(), client.DialOptions(ctx)...)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	res, err := proto.NewNodeServiceClient(conn).Info(ctx, &proto.NodeInfoRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, srvHost.ID().String(), res.GetId())
+	assert.NotEmpty(t, cliHost.Peerstore().Addrs(srvHost.ID()))
+}
+
+func TestResolverInvalidTarget(t *testing.T) {
+	ctx := context.Background()
+	_, cliHost := newConnectedHosts(t)
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	_, err := grpc.Dial("libp2p:///not-a-peer", client.DialOptions(ctx)...)
+	assert.ErrorContains(t, err, "invalid peer ID")
+}
@@ -0,0 +1,406 @@
+package libp2pgrpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ResourceService is the name of the resource manager service scope
+// the libp2p streams carrying gRPC are attached to, unless changed with
+// WithResourceService. Limits for it can be set in the host's
+// network.ResourceManager like for any other libp2p service.
+const ResourceService = "libp2p-grpc"
+
+// WithResourceService attaches the streams of the Server to the given
+// resource manager service scope instead of ResourceService.
+func WithResourceService(name string) ServerOption {
+	return ServerOption{apply: func(s *Server) {
+		s.limits.service = name
+	}}
+}
+
+// WithMaxPeerConns caps the number of gRPC connections, counting the
+// calls in flight on the stream transport, a single peer may hold to
+// the Server across all of its protocols. The calls of streams past
+// the cap fail with codes.ResourceExhausted until a slot is free, and
+// a peer keeping too many such streams open has the next ones reset.
+// Zero means no cap.
+func WithMaxPeerConns(n int) ServerOption {
+	return ServerOption{apply: func(s *Server) {
+		s.limits.maxPeerConns = n
+	}}
+}
+
+// WithStreamMemory reserves the given number of bytes in the resource
+// manager for every libp2p stream accepted by the Server. The calls of
+// streams whose reservation is refused by the resource manager fail
+// with codes.ResourceExhausted.
+func WithStreamMemory(bytes int) ServerOption {
+	return ServerOption{apply: func(s *Server) {
+		s.limits.streamMemory = bytes
+	}}
+}
+
+// WithMethodLimit caps the number of concurrent calls to fullMethod,
+// e.g. "/proto.v1.NodeService/Info", across all peers. Calls past the
+// cap fail with codes.ResourceExhausted.
+func WithMethodLimit(fullMethod string, n int) ServerOption {
+	return ServerOption{apply: func(s *Server) {
+		if s.limits.methods == nil {
+			s.limits.methods = newMethodLimiter()
+			s.intercept(s.limits.methods.unaryInterceptor, s.limits.methods.streamInterceptor)
+		}
+		s.limits.methods.limits[fullMethod] = n
+	}}
+}
+
+// resourceLimits holds the limits applied to the streams and calls of
+// a Server.
+type resourceLimits struct {
+	service      string
+	maxPeerConns int
+	streamMemory int
+	methods      *methodLimiter
+
+	allowTransient bool
+
+	// the streams admitted per peer, by every listener of the Server
+	// and by the stream transport, and those held open with their
+	// calls refused
+	mu      sync.Mutex
+	conns   map[peer.ID]int
+	refused map[peer.ID]int
+}
+
+// maxRefusedPeerConns bounds the streams accepted past the limits of a
+// Server that a peer may keep open, their calls being refused. The
+// streams past it are reset.
+const maxRefusedPeerConns = 4
+
+// admit attaches stream and counts it against the cap of its peer. An
+// admitted stream must be released once it is done.
+func (r *resourceLimits) admit(stream network.Stream) error {
+	if err := r.attach(stream); err != nil {
+		return err
+	}
+	return r.take(stream.Conn().RemotePeer())
+}
+
+// take counts a stream of id against the cap of the peer.
+func (r *resourceLimits) take(id peer.ID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxPeerConns > 0 && r.conns[id] >= r.maxPeerConns {
+		return network.ErrResourceLimitExceeded
+	}
+	if r.conns == nil {
+		r.conns = make(map[peer.ID]int)
+	}
+	r.conns[id]++
+	return nil
+}
+
+func (r *resourceLimits) release(id peer.ID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conns[id]--; r.conns[id] <= 0 {
+		delete(r.conns, id)
+	}
+}
+
+// hold counts a refused stream of id against maxRefusedPeerConns. It
+// reports false if the peer has no room left for it.
+func (r *resourceLimits) hold(id peer.ID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.refused[id] >= maxRefusedPeerConns {
+		return false
+	}
+	if r.refused == nil {
+		r.refused = make(map[peer.ID]int)
+	}
+	r.refused[id]++
+	return true
+}
+
+func (r *resourceLimits) unhold(id peer.ID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.refused[id]--; r.refused[id] <= 0 {
+		delete(r.refused, id)
+	}
+}
+
+// attach attaches stream to the service scope and reserves its memory.
+// Streams over transient connections are refused, unless allowed by
+// WithTransientConns.
+func (r *resourceLimits) attach(stream network.Stream) error {
+	if err := r.checkTransient(stream); err != nil {
+		return err
+	}
+	if err := r.setService(stream); err != nil {
+		return err
+	}
+	return r.reserveMemory(stream)
+}
+
+func (r *resourceLimits) checkTransient(stream network.Stream) error {
+	if stream.Conn().Stat().Transient && !r.allowTransient {
+		return network.ErrTransientConn
+	}
+	return nil
+}
+
+func (r *resourceLimits) setService(stream network.Stream) error {
+	return stream.Scope().SetService(r.service)
+}
+
+func (r *resourceLimits) reserveMemory(stream network.Stream) error {
+	if r.streamMemory > 0 {
+		return stream.Scope().ReserveMemory(r.streamMemory, network.ReservationPriorityMedium)
+	}
+	return nil
+}
+
+// refusalError is the status of the calls of a stream refused by the
+// limits of the Server self.
+func refusalError(self peer.ID, err error) error {
+	if errors.Is(err, network.ErrTransientConn) {
+		return transientConnError(self)
+	}
+	return status.Errorf(codes.ResourceExhausted, "libp2pgrpc: stream refused: %s", err)
+}
+
+// limitListener applies the limits of the Server to the streams
+// accepted by l. Streams past the limits are still accepted, but their
+// calls are refused until the limits admit them, see admitCall: gRPC
+// then answers them with the refusal status in the wire format served
+// on l.
+func (s *Server) limitListener(l net.Listener) net.Listener {
+	return &resourceListener{Listener: l, limits: &s.limits}
+}
+
+type resourceListener struct {
+	net.Listener
+	limits *resourceLimits
+}
+
+func (l *resourceListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		stream, ok := conn.(network.Stream)
+		if !ok {
+			return conn, nil
+		}
+
+		a := &admission{limits: l.limits, stream: stream, id: stream.Conn().RemotePeer()}
+		if err := a.admit(); err != nil {
+			if !l.limits.hold(a.id) {
+				log.Debugw("reset gRPC stream", "peer", a.id, "err", err)
+				stream.Reset()
+				continue
+			}
+			log.Debugw("refusing the calls of gRPC stream", "peer", a.id, "err", err)
+			a.refused = true
+		}
+		c, _ := hookConn(conn)
+		c.admission = a
+		c.onClose = append(c.onClose, a.release)
+		return c, nil
+	}
+}
+
+// admission tracks the admission of a stream accepted by a
+// resourceListener. A stream the limits refused is admitted again on
+// every call, so that it carries calls once a slot is free.
+type admission struct {
+	limits *resourceLimits
+	stream network.Stream
+	id     peer.ID
+
+	mu sync.Mutex
+	// the steps of admit done so far
+	service, memory bool
+	admitted        bool
+	// refused is set while the stream is counted by limits.hold.
+	refused bool
+	done    bool
+}
+
+// admit admits the stream if the limits allow it. It may be called
+// again after a refusal, and does nothing once the stream is admitted
+// or closed.
+func (a *admission) admit() error {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.admitted || a.done {
+		return nil
+	}
+	if err := a.limits.checkTransient(a.stream); err != nil {
+		return err
+	}
+	if !a.service {
+		if err := a.limits.setService(a.stream); err != nil {
+			return err
+		}
+		a.service = true
+	}
+	if !a.memory {
+		if err := a.limits.reserveMemory(a.stream); err != nil {
+			return err
+		}
+		a.memory = true
+	}
+	if err := a.limits.take(a.id); err != nil {
+		return err
+	}
+
+	a.admitted = true
+	if a.refused {
+		a.refused = false
+		a.limits.unhold(a.id)
+	}
+	return nil
+}
+
+// release gives back what the stream holds, once it is closed or reset.
+func (a *admission) release() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.done {
+		return
+	}
+	a.done = true
+	if a.admitted {
+		a.limits.release(a.id)
+	}
+	if a.refused {
+		a.limits.unhold(a.id)
+	}
+}
+
+// admitCall refuses the calls of the streams the limits of the Server
+// don't admit. The admission of a stream reaches the call through the
+// AuthInfo of NewCredentials.
+func (s *Server) admitCall(ctx context.Context) error {
+	info, ok := PeerFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	if err := info.admission.admit(); err != nil {
+		return refusalError(s.host.ID(), err)
+	}
+	return nil
+}
+
+// admitStreamConn refuses the requests of the streams the limits of
+// the Server don't admit, on the HTTP listeners, before they reach
+// gRPC.
+func (s *Server) admitStreamConn(conn *streamConn) error {
+	if err := conn.info.admission.admit(); err != nil {
+		return refusalError(s.host.ID(), err)
+	}
+	return nil
+}
+
+func (s *Server) admitUnary(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := s.admitCall(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) admitStream(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.admitCall(ss.Context()); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// admissionOf returns the admission of s, if it was accepted by a
+// resourceListener.
+func admissionOf(s network.Stream) *admission {
+	if c, ok := s.(*hookedConn); ok {
+		return c.admission
+	}
+	return nil
+}
+
+// methodLimiter caps the concurrent calls per method.
+type methodLimiter struct {
+	limits map[string]int
+
+	mu     sync.Mutex
+	active map[string]int
+}
+
+func newMethodLimiter() *methodLimiter {
+	return &methodLimiter{
+		limits: make(map[string]int),
+		active: make(map[string]int),
+	}
+}
+
+func (m *methodLimiter) acquire(fullMethod string) error {
+	limit, ok := m.limits[fullMethod]
+	if !ok || limit <= 0 {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.active[fullMethod] >= limit {
+		return status.Errorf(codes.ResourceExhausted, "libp2pgrpc: too many concurrent calls to %s", fullMethod)
+	}
+	m.active[fullMethod]++
+	return nil
+}
+
+func (m *methodLimiter) release(fullMethod string) {
+	if limit, ok := m.limits[fullMethod]; !ok || limit <= 0 {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.active[fullMethod]--
+}
+
+func (m *methodLimiter) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := m.acquire(info.FullMethod); err != nil {
+		return nil, err
+	}
+	defer m.release(info.FullMethod)
+	return handler(ctx, req)
+}
+
+func (m *methodLimiter) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := m.acquire(info.FullMethod); err != nil {
+		return err
+	}
+	defer m.release(info.FullMethod)
+	return handler(srv, ss)
+}
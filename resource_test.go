@@ -0,0 +1,211 @@
+package libp2pgrpc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/status"
+
+	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
+	proto "github.com/drgomesp/go-libp2p-grpc/proto/v1"
+)
+
+// blockingService holds every UnaryCall until release is closed.
+type blockingService struct {
+	testpb.UnimplementedTestServiceServer
+
+	started chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingService) UnaryCall(ctx context.Context, _ *testpb.SimpleRequest) (*testpb.SimpleResponse, error) {
+	s.started <- struct{}{}
+	select {
+	case <-s.release:
+		return &testpb.SimpleResponse{}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func serveLimited(t *testing.T, srvHost host.Host, opts ...grpc.ServerOption) {
+	srv, err := libp2pgrpc.NewGrpcServer(context.Background(), srvHost, opts...)
+	assert.NoError(t, err)
+	t.Cleanup(srv.Stop)
+
+	serveNodeInfo(t, srv, srvHost)
+}
+
+// assertRefused checks that the RPCs on conn are refused before they
+// reach the server.
+func assertRefused(t *testing.T, conn grpc.ClientConnInterface) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	_, err := proto.NewNodeServiceClient(conn).Info(ctx, &proto.NodeInfoRequest{}, grpc.WaitForReady(true))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func serviceStat(t *testing.T, h host.Host, name string) network.ScopeStat {
+	var stat network.ScopeStat
+	err := h.Network().ResourceManager().ViewService(name, func(s network.ServiceScope) error {
+		stat = s.Stat()
+		return nil
+	})
+	assert.NoError(t, err)
+	return stat
+}
+
+func TestResourceServiceScope(t *testing.T) {
+	hosts := newHosts(t, 2)
+	srvHost, cliHost := hosts[0], hosts[1]
+	serveLimited(t, srvHost, libp2pgrpc.WithStreamMemory(1<<20))
+
+	conn := dialNode(t, cliHost, srvHost)
+	assert.NoError(t, callNode(conn))
+
+	stat := serviceStat(t, srvHost, libp2pgrpc.ResourceService)
+	assert.Equal(t, 1, stat.NumStreamsInbound)
+	assert.GreaterOrEqual(t, stat.Memory, int64(1<<20))
+	assert.Equal(t, 1, serviceStat(t, cliHost, libp2pgrpc.ResourceService).NumStreamsOutbound)
+
+	conn.Close()
+	assert.Eventually(t, func() bool {
+		return serviceStat(t, srvHost, libp2pgrpc.ResourceService).NumStreamsInbound == 0
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestResourceCustomService(t *testing.T) {
+	hosts := newHosts(t, 2)
+	srvHost, cliHost := hosts[0], hosts[1]
+	serveLimited(t, srvHost, libp2pgrpc.WithResourceService("myapp-grpc"))
+
+	assert.NoError(t, callNode(dialNode(t, cliHost, srvHost)))
+	assert.Equal(t, 1, serviceStat(t, srvHost, "myapp-grpc").NumStreamsInbound)
+}
+
+func TestResourceStreamMemoryRefused(t *testing.T) {
+	hosts := newHosts(t, 2)
+	srvHost, cliHost := hosts[0], hosts[1]
+	serveLimited(t, srvHost, libp2pgrpc.WithStreamMemory(1<<40))
+
+	assertRefused(t, dialNode(t, cliHost, srvHost))
+}
+
+func TestResourceMaxPeerConns(t *testing.T) {
+	hosts := newHosts(t, 2)
+	srvHost, cliHost := hosts[0], hosts[1]
+	serveLimited(t, srvHost, libp2pgrpc.WithMaxPeerConns(1))
+
+	first := dialNode(t, cliHost, srvHost)
+	assert.NoError(t, callNode(first))
+
+	second := dialNode(t, cliHost, srvHost)
+	assertRefused(t, second)
+
+	// the slot is freed once the server sees the first connection gone
+	first.Close()
+	assert.Eventually(t, func() bool {
+		return callNode(second) == nil
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestResourceMaxPeerConnsShared(t *testing.T) {
+	const other = "/myapp/grpc/1.0.0"
+	hosts := newHosts(t, 2)
+	srvHost, cliHost := hosts[0], hosts[1]
+	serveLimited(t, srvHost,
+		libp2pgrpc.WithProtocols(libp2pgrpc.ProtocolID, other),
+		libp2pgrpc.WithStreamTransport(),
+		libp2pgrpc.WithGrpcWeb(),
+		libp2pgrpc.WithMaxPeerConns(1),
+	)
+
+	first := dialNode(t, cliHost, srvHost)
+	assert.NoError(t, callNode(first))
+
+	// the cap holds across the protocols and transports of the server
+	client := libp2pgrpc.NewClient(cliHost, other)
+	defer client.Close()
+	second, err := client.Dial(context.Background(), srvHost.ID())
+	assert.NoError(t, err)
+	defer second.Close()
+	assertRefused(t, second)
+
+	assertRefused(t, client.DialStreams(srvHost.ID()))
+
+	web := client.DialGrpcWeb(srvHost.ID())
+	defer web.Close()
+	_, err = proto.NewNodeServiceClient(web).Info(context.Background(), &proto.NodeInfoRequest{})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	first.Close()
+	assert.Eventually(t, func() bool {
+		return callNode(second) == nil
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestResourceRefusedConnsReset(t *testing.T) {
+	hosts := newHosts(t, 2)
+	srvHost, cliHost := hosts[0], hosts[1]
+	serveLimited(t, srvHost, libp2pgrpc.WithMaxPeerConns(1))
+
+	first := dialNode(t, cliHost, srvHost)
+	assert.NoError(t, callNode(first))
+
+	// a few streams past the cap are held with their calls refused,
+	// the next ones are reset
+	for i := 0; ; i++ {
+		if !assert.Less(t, i, 10) {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		_, err := proto.NewNodeServiceClient(dialNode(t, cliHost, srvHost)).Info(ctx, &proto.NodeInfoRequest{})
+		cancel()
+		if status.Code(err) != codes.ResourceExhausted {
+			assert.Equal(t, codes.Unavailable, status.Code(err))
+			assert.Greater(t, i, 0)
+			return
+		}
+	}
+}
+
+func TestResourceMethodLimit(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 2)
+	srvHost, cliHost := hosts[0], hosts[1]
+
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost,
+		libp2pgrpc.WithMethodLimit("/grpc.testing.TestService/UnaryCall", 1))
+	assert.NoError(t, err)
+	defer srv.Stop()
+
+	svc := &blockingService{started: make(chan struct{}, 1), release: make(chan struct{})}
+	testpb.RegisterTestServiceServer(srv, svc)
+	go srv.Serve()
+
+	client := testpb.NewTestServiceClient(dialNode(t, cliHost, srvHost))
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.UnaryCall(ctx, &testpb.SimpleRequest{}, grpc.WaitForReady(true))
+		done <- err
+	}()
+	<-svc.started
+
+	_, err = client.UnaryCall(ctx, &testpb.SimpleRequest{})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	close(svc.release)
+	assert.NoError(t, <-done)
+
+	_, err = client.UnaryCall(ctx, &testpb.SimpleRequest{})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,60 @@
+package libp2pgrpc
+
+import (
+	"net"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/test"
+	"github.com/stretchr/testify/assert"
+)
+
+// peerStream is a stream from a given peer.
+type peerStream struct {
+	nopStream
+	id peer.ID
+}
+
+func (s peerStream) Conn() network.Conn { return peerConn{id: s.id} }
+
+type peerConn struct {
+	network.Conn
+	id peer.ID
+}
+
+func (c peerConn) RemotePeer() peer.ID { return c.id }
+
+func TestLimitedConnReleasedOnce(t *testing.T) {
+	id := test.RandPeerIDFatal(t)
+	for name, end := range map[string]func(*hookedConn){
+		"close":           func(c *hookedConn) { c.Close() },
+		"reset":           func(c *hookedConn) { c.Reset() },
+		"reset and close": func(c *hookedConn) { c.Reset(); c.Close() },
+	} {
+		t.Run(name, func(t *testing.T) {
+			limits := &resourceLimits{conns: map[peer.ID]int{id: 2}}
+			conn, other := net.Pipe()
+			defer other.Close()
+			a := &admission{limits: limits, id: id, admitted: true}
+			end(&hookedConn{Stream: peerStream{id: id}, conn: conn, admission: a, onClose: []func(){a.release}})
+			assert.Equal(t, 1, limits.conns[id])
+		})
+	}
+}
+
+func TestResourceLimitsHold(t *testing.T) {
+	id := test.RandPeerIDFatal(t)
+	limits := &resourceLimits{}
+	for i := 0; i < maxRefusedPeerConns; i++ {
+		assert.True(t, limits.hold(id))
+	}
+	assert.False(t, limits.hold(id))
+	assert.True(t, limits.hold(test.RandPeerIDFatal(t)))
+
+	a := &admission{limits: limits, id: id, refused: true}
+	a.release()
+	a.release()
+	assert.True(t, limits.hold(id))
+	assert.False(t, limits.hold(id))
+}
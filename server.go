@@ -2,44 +2,293 @@ package libp2pgrpc
 
 import (
 	"context"
+	"io"
+	"net"
+	"sync"
 
 	gostream "github.com/libp2p/go-libp2p-gostream"
+	"github.com/libp2p/go-libp2p/core/discovery"
 	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/protocol"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
 )
 
 var _ grpc.ServiceRegistrar = &Server{}
+var _ io.Closer = &Server{}
 
 // only for unit test
 var _gostream_Listen = gostream.Listen
 
+// ServerOption configures the libp2p side of a Server. It satisfies
+// grpc.ServerOption, so it can be passed to NewGrpcServer alongside
+// the regular gRPC server options.
+type ServerOption struct {
+	grpc.EmptyServerOption
+	apply func(*Server)
+}
+
+// WithProtocol makes the Server listen on the given protocol ID
+// instead of the default ProtocolID.
+func WithProtocol(p protocol.ID) ServerOption {
+	return WithProtocols(p)
+}
+
+// WithProtocols makes the Server listen on every one of the given
+// protocol IDs instead of the default ProtocolID.
+func WithProtocols(ps ...protocol.ID) ServerOption {
+	return ServerOption{apply: func(s *Server) {
+		s.protocols = append([]protocol.ID(nil), ps...)
+	}}
+}
+
+// defaultMaxRecvMsgSize is the default receive limit of gRPC servers.
+const defaultMaxRecvMsgSize = 4 << 20
+
+// WithMaxRecvMsgSize sets the largest message the Server accepts, in
+// bytes. Use it instead of grpc.MaxRecvMsgSize, which it sets too: it
+// also bounds the frames of gRPC-Web and of the stream transport,
+// which the gRPC server doesn't read itself. The default is 4MiB.
+func WithMaxRecvMsgSize(n int) ServerOption {
+	return ServerOption{apply: func(s *Server) {
+		s.maxRecvMsgSize = n
+		s.grpcOpts = append(s.grpcOpts, grpc.MaxRecvMsgSize(n))
+	}}
+}
+
 type Server struct {
-	host host.Host
-	grpc *grpc.Server
-	ctx  context.Context
+	host      host.Host
+	grpc      *grpc.Server
+	ctx       context.Context
+	protocols []protocol.ID
+	local     *localListener
+	grpcOpts  []grpc.ServerOption
+	limits    resourceLimits
+	metrics   MetricsTracer
+
+	maxRecvMsgSize int
+
+	// the interceptors installed by ServerOptions, kept to also apply
+	// them to the RPCs of the stream transport.
+	unaryInterceptors  []grpc.UnaryServerInterceptor
+	streamInterceptors []grpc.StreamServerInterceptor
+	services           map[string]*streamService
+	streamProtocols    []protocol.ID
+	streamCalls        *streamCalls
+
+	advertisers []discovery.Advertiser
+	mdns        bool
+	reflection  bool
+	health      *health.Server
+	gateway     *httpGateway
+	grpcWeb     []protocol.ID
+
+	stopOnce sync.Once
+	done     chan struct{}
 }
 
 // NewGrpcServer creates a Server object with the given LibP2P host
-// and protocol.
+// and protocol. Cancelling ctx stops the server.
 func NewGrpcServer(ctx context.Context, h host.Host, opts ...grpc.ServerOption) (*Server, error) {
-	grpcServer := grpc.NewServer(opts...)
 	srv := &Server{
-		host: h,
-		ctx:  ctx,
-		grpc: grpcServer,
+		host:      h,
+		ctx:       ctx,
+		protocols: []protocol.ID{ProtocolID},
+		local:     newLocalListener(h),
+		limits:    resourceLimits{service: ResourceService},
+		done:      make(chan struct{}),
+
+		streamCalls:    newStreamCalls(ctx),
+		maxRecvMsgSize: defaultMaxRecvMsgSize,
 	}
+
+	grpcOpts := make([]grpc.ServerOption, 0, len(opts))
+	for _, opt := range opts {
+		if o, ok := opt.(ServerOption); ok {
+			o.apply(srv)
+			continue
+		}
+		grpcOpts = append(grpcOpts, opt)
+	}
+
+	// the libp2p credentials go first, so callers can still override
+	// them with their own grpc.Creds, and so does the refusal of the
+	// calls past the limits, so no other interceptor sees them.
+	grpcOpts = append([]grpc.ServerOption{
+		grpc.Creds(NewCredentials()),
+		grpc.ChainUnaryInterceptor(srv.admitUnary),
+		grpc.ChainStreamInterceptor(srv.admitStream),
+	}, grpcOpts...)
+	grpcOpts = append(grpcOpts, srv.grpcOpts...)
+	srv.grpc = grpc.NewServer(grpcOpts...)
+	srv.registerBuiltinServices()
+
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				srv.Stop()
+			case <-srv.done:
+			}
+		}()
+	}
+
 	return srv, nil
 }
 
+// Protocols returns the protocol IDs the Server listens on.
+func (s *Server) Protocols() []protocol.ID {
+	return append([]protocol.ID(nil), s.protocols...)
+}
+
 // Serve start gRPC serve after NewGrpcServer() and services register
 func (s *Server) Serve() error {
-	listener, err := _gostream_Listen(s.host, ProtocolID)
-	if err != nil {
-		return err
+	if s.stopped() {
+		return grpc.ErrServerStopped
+	}
+
+	protocols := s.protocols
+	if len(protocols) == 0 {
+		protocols = []protocol.ID{ProtocolID}
+	}
+
+	listeners := make([]net.Listener, 0, len(protocols))
+	for _, p := range protocols {
+		listener, err := _gostream_Listen(s.host, p)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, s.meterListener(s.limitListener(listener)))
+	}
+	if s.local != nil {
+		listeners = append(listeners, s.local)
+	}
+	listener := newMultiListener(listeners...)
+
+	if s.mdns {
+		stopMDNS, err := s.startMDNS()
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		defer stopMDNS()
+	}
+
+	if s.gateway != nil {
+		stopHTTP, err := s.serveHTTP(s.ctx)
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		defer stopHTTP()
+	}
+
+	if len(s.grpcWeb) > 0 {
+		stopGrpcWeb, err := s.serveGrpcWeb()
+		if err != nil {
+			listener.Close()
+			return err
+		}
+		defer stopGrpcWeb()
+	}
+
+	if len(s.streamProtocols) > 0 {
+		stopStreams := s.serveStreams()
+		defer stopStreams()
 	}
+
+	if len(s.advertisers) > 0 {
+		ctx, cancel := context.WithCancel(s.ctx)
+		defer cancel()
+		s.advertise(ctx)
+	}
+
+	s.markServing()
 	return s.grpc.Serve(listener)
 }
 
 func (s *Server) RegisterService(serviceDesc *grpc.ServiceDesc, srv interface{}) {
 	s.grpc.RegisterService(serviceDesc, srv)
+	s.registerStreamService(serviceDesc, srv)
+}
+
+// GetServiceInfo returns the services registered on the Server.
+func (s *Server) GetServiceInfo() map[string]grpc.ServiceInfo {
+	return s.grpc.GetServiceInfo()
+}
+
+// intercept installs server interceptors on behalf of a ServerOption.
+func (s *Server) intercept(unary grpc.UnaryServerInterceptor, stream grpc.StreamServerInterceptor) {
+	s.grpcOpts = append(s.grpcOpts,
+		grpc.ChainUnaryInterceptor(unary),
+		grpc.ChainStreamInterceptor(stream),
+	)
+	s.unaryInterceptors = append(s.unaryInterceptors, unary)
+	s.streamInterceptors = append(s.streamInterceptors, stream)
+}
+
+// Stop stops the server immediately. It closes the libp2p listeners,
+// closes all open connections and cancels in-flight RPCs.
+func (s *Server) Stop() {
+	s.markStopped()
+	s.markNotServing()
+	s.stopStreams()
+	s.streamCalls.cancel()
+	s.grpc.Stop()
+	s.local.Close()
+}
+
+// GracefulStop stops the server from accepting new connections and
+// RPCs and blocks until the in-flight RPCs are finished. If ctx is
+// done before that happens, the server is stopped forcefully and the
+// context error is returned.
+func (s *Server) GracefulStop(ctx context.Context) error {
+	s.markStopped()
+	s.markNotServing()
+
+	s.stopStreams()
+	drained := make(chan struct{})
+	go func() {
+		s.grpc.GracefulStop()
+		s.streamCalls.wait()
+		s.streamCalls.cancel()
+		s.local.Close()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		s.grpc.Stop()
+		s.streamCalls.cancel()
+		<-drained
+		return ctx.Err()
+	}
+}
+
+// Close stops the server immediately. It is equivalent to Stop.
+func (s *Server) Close() error {
+	s.Stop()
+	return nil
+}
+
+func (s *Server) markStopped() {
+	s.stopOnce.Do(func() {
+		if s.done != nil {
+			close(s.done)
+		}
+	})
+}
+
+func (s *Server) stopped() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
 }
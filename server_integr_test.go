@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"net"
 	"net/http"
 	"testing"
 	"time"
@@ -16,8 +17,10 @@ import (
 	"github.com/multiformats/go-multiaddr"
 	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
 
 	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
 	proto "github.com/drgomesp/go-libp2p-grpc/proto/v1"
@@ -48,7 +51,7 @@ func addresses(s *NodeInfoService) []string {
 	return res
 }
 
-func newHost(t *testing.T, listen multiaddr.Multiaddr) host.Host {
+func newHost(t testing.TB, listen multiaddr.Multiaddr) host.Host {
 	h, err := libp2p.New(
 		libp2p.ListenAddrs(listen),
 	)
@@ -58,6 +61,63 @@ func newHost(t *testing.T, listen multiaddr.Multiaddr) host.Host {
 	return h
 }
 
+func newConnectedHosts(t *testing.T) (host.Host, host.Host) {
+	m1, _ := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/10000")
+	m2, _ := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/10001")
+
+	srvHost := newHost(t, m1)
+	t.Cleanup(func() { srvHost.Close() })
+
+	cliHost := newHost(t, m2)
+	t.Cleanup(func() { cliHost.Close() })
+
+	srvHost.Peerstore().AddAddrs(cliHost.ID(), cliHost.Addrs(), peerstore.PermanentAddrTTL)
+	cliHost.Peerstore().AddAddrs(srvHost.ID(), srvHost.Addrs(), peerstore.PermanentAddrTTL)
+
+	return srvHost, cliHost
+}
+
+// newHosts creates n hosts listening on random loopback ports, each
+// knowing the addresses of all the others.
+func newHosts(t testing.TB, n int) []host.Host {
+	listen, _ := multiaddr.NewMultiaddr("/ip4/127.0.0.1/tcp/0")
+
+	hosts := make([]host.Host, n)
+	for i := range hosts {
+		h := newHost(t, listen)
+		t.Cleanup(func() { h.Close() })
+		hosts[i] = h
+	}
+	for _, h := range hosts {
+		for _, other := range hosts {
+			if h != other {
+				h.Peerstore().AddAddrs(other.ID(), other.Addrs(), peerstore.PermanentAddrTTL)
+			}
+		}
+	}
+	return hosts
+}
+
+func serveNodeInfo(t *testing.T, srv *libp2pgrpc.Server, h host.Host) <-chan error {
+	proto.RegisterNodeServiceServer(srv, &NodeInfoService{host: h})
+
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve() }()
+	return served
+}
+
+func callInfo(ctx context.Context, cliHost host.Host, srvHost host.Host) error {
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	conn, err := client.Dial(ctx, srvHost.ID())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = proto.NewNodeServiceClient(conn).Info(ctx, &proto.NodeInfoRequest{})
+	return err
+}
+
 func TestGrpc(t *testing.T) {
 	ctx := context.Background()
 
@@ -139,9 +199,13 @@ func TestGrpcGateway(t *testing.T) {
 	assert.Equal(t, addresses(svc), res.Addresses)
 	assert.Equal(t, protocol.ConvertToStrings(srvHost.Mux().Protocols()), res.Protocols)
 
-	go func() {
-		http.ListenAndServe(":4000", mux)
-	}()
+	// bind before the request: serving from a goroutine with
+	// http.ListenAndServe races the GET below
+	listener, err := net.Listen("tcp", ":4000")
+	assert.NoError(t, err)
+	httpServer := &http.Server{Handler: mux}
+	defer httpServer.Close()
+	go httpServer.Serve(listener)
 	httpClient := &http.Client{}
 	response, err := httpClient.Get(
 		"http://localhost:4000/v1/node/info",
@@ -196,3 +260,60 @@ func TestGrpcBadProtocol(t *testing.T) {
 	assert.Error(t, err)
 	assert.Equal(t, "rpc error: code = Unavailable desc = connection error: desc = \"transport: Error while dialing: failed to negotiate protocol: protocols not supported: [/bad/proto]\"", err.Error())
 }
+
+func TestGrpcCustomProtocol(t *testing.T) {
+	ctx := context.Background()
+
+	srvHost, cliHost := newConnectedHosts(t)
+
+	admin := protocol.ID("/myapp/grpc/admin/1.0.0")
+	public := protocol.ID("/myapp/grpc/public/1.0.0")
+
+	adminSrv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost, libp2pgrpc.WithProtocol(admin))
+	assert.NoError(t, err)
+	defer adminSrv.Stop()
+	proto.RegisterNodeServiceServer(adminSrv, &NodeInfoService{host: srvHost})
+	go adminSrv.Serve()
+
+	publicSrv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost, libp2pgrpc.WithProtocols(public, libp2pgrpc.ProtocolID))
+	assert.NoError(t, err)
+	defer publicSrv.Stop()
+	proto.RegisterNodeServiceServer(publicSrv, &NodeInfoService{host: srvHost})
+	go publicSrv.Serve()
+
+	for _, p := range []protocol.ID{admin, public, libp2pgrpc.ProtocolID} {
+		client := libp2pgrpc.NewClient(cliHost, p)
+		conn, err := client.Dial(ctx, srvHost.ID())
+		assert.NoError(t, err)
+
+		c := proto.NewNodeServiceClient(conn)
+		res, err := c.Info(ctx, &proto.NodeInfoRequest{})
+		assert.NoError(t, err, p)
+		assert.Equal(t, srvHost.ID().String(), res.GetId())
+		conn.Close()
+	}
+}
+
+func TestGrpcCustomProtocolMismatch(t *testing.T) {
+	ctx := context.Background()
+
+	srvHost, cliHost := newConnectedHosts(t)
+
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost)
+	assert.NoError(t, err)
+	defer srv.Stop()
+	proto.RegisterNodeServiceServer(srv, &NodeInfoService{host: srvHost})
+	go srv.Serve()
+
+	client := libp2pgrpc.NewClient(cliHost, "/myapp/grpc/admin/1.0.0")
+	conn, err := client.Dial(ctx, srvHost.ID())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	c := proto.NewNodeServiceClient(conn)
+	res, err := c.Info(ctx, &proto.NodeInfoRequest{})
+
+	assert.Nil(t, res)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	assert.Contains(t, err.Error(), "protocols not supported: [/myapp/grpc/admin/1.0.0]")
+}
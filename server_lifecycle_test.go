@@ -0,0 +1,124 @@
+package libp2pgrpc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+
+	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
+)
+
+func TestServerStop(t *testing.T) {
+	ctx := context.Background()
+	srvHost, cliHost := newConnectedHosts(t)
+
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost)
+	assert.NoError(t, err)
+	served := serveNodeInfo(t, srv, srvHost)
+
+	assert.NoError(t, callInfo(ctx, cliHost, srvHost))
+
+	srv.Stop()
+	assert.NoError(t, <-served)
+	assert.Error(t, callInfo(ctx, cliHost, srvHost))
+	assert.Equal(t, grpc.ErrServerStopped, srv.Serve())
+}
+
+func TestServerGracefulStop(t *testing.T) {
+	ctx := context.Background()
+	srvHost, cliHost := newConnectedHosts(t)
+
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost)
+	assert.NoError(t, err)
+	served := serveNodeInfo(t, srv, srvHost)
+
+	assert.NoError(t, callInfo(ctx, cliHost, srvHost))
+
+	stopCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	assert.NoError(t, srv.GracefulStop(stopCtx))
+	assert.NoError(t, <-served)
+	assert.Error(t, callInfo(ctx, cliHost, srvHost))
+}
+
+func TestServerClose(t *testing.T) {
+	ctx := context.Background()
+	srvHost, cliHost := newConnectedHosts(t)
+
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost)
+	assert.NoError(t, err)
+	served := serveNodeInfo(t, srv, srvHost)
+
+	assert.NoError(t, callInfo(ctx, cliHost, srvHost))
+	assert.NoError(t, srv.Close())
+	assert.NoError(t, <-served)
+}
+
+func TestServerStopsOnContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	srvHost, cliHost := newConnectedHosts(t)
+
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost)
+	assert.NoError(t, err)
+	served := serveNodeInfo(t, srv, srvHost)
+
+	assert.NoError(t, callInfo(context.Background(), cliHost, srvHost))
+
+	cancel()
+	select {
+	case err := <-served:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop after its context was cancelled")
+	}
+}
+
+func TestServerGracefulStopStreamTransport(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 2)
+	srvHost, cliHost := hosts[0], hosts[1]
+
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost, libp2pgrpc.WithStreamTransport())
+	assert.NoError(t, err)
+	svc := &blockingService{started: make(chan struct{}, 1), release: make(chan struct{})}
+	testpb.RegisterTestServiceServer(srv, svc)
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve() }()
+
+	assert.Eventually(t, func() bool {
+		for _, p := range srvHost.Mux().Protocols() {
+			if p == libp2pgrpc.StreamProtocolID {
+				return true
+			}
+		}
+		return false
+	}, 2*time.Second, 10*time.Millisecond)
+
+	client := testpb.NewTestServiceClient(libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID).DialStreams(srvHost.ID()))
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.UnaryCall(ctx, &testpb.SimpleRequest{})
+		done <- err
+	}()
+	<-svc.started
+
+	stopCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	stopped := make(chan error, 1)
+	go func() { stopped <- srv.GracefulStop(stopCtx) }()
+
+	select {
+	case <-stopped:
+		t.Fatal("GracefulStop returned with an RPC in flight")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	close(svc.release)
+	assert.NoError(t, <-done)
+	assert.NoError(t, <-stopped)
+	assert.NoError(t, <-served)
+}
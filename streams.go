@@ -0,0 +1,633 @@
+package libp2pgrpc
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	grpcproto "google.golang.org/grpc/encoding/proto"
+	"google.golang.org/grpc/metadata"
+	grpcpeer "google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// StreamProtocolID is the protocol ID of the stream transport, see
+// WithStreamTransport.
+const StreamProtocolID protocol.ID = "/libp2p/grpc-streams/1.0.0"
+
+// Frame types of the stream transport. Each frame is the type byte, the
+// uvarint length of the payload and the payload.
+const (
+	// frameHeader carries metadata: the request headers, which open
+	// every stream, or the response headers.
+	frameHeader byte = iota
+	// frameMessage carries one serialized message.
+	frameMessage
+	// frameTrailer carries the status and trailers that end a response.
+	frameTrailer
+)
+
+// Pseudo metadata keys of the header and trailer frames.
+const (
+	pathKey          = ":path"
+	timeoutKey       = "grpc-timeout"
+	statusKey        = ":status"
+	messageKey       = ":message"
+	statusDetailsKey = "grpc-status-details-bin"
+)
+
+// maxFrameSize bounds the size of a single stream transport frame
+// received by a client.
+const maxFrameSize = 64 << 20
+
+// WithStreamTransport makes the Server also serve RPCs on the stream
+// transport, on the given protocol IDs or on StreamProtocolID if none
+// are given. There, every RPC runs over a libp2p stream of its own
+// instead of sharing an HTTP/2 connection, which avoids head-of-line
+// blocking between RPCs and leaves flow control to the libp2p muxer.
+// See Client.DialStreams.
+//
+// The stream transport reaches the same registered services and the
+// interceptors installed by ServerOptions, such as WithAuthorization
+// and WithUnaryInterceptors. Options that configure the gRPC server
+// itself, such as grpc.ChainUnaryInterceptor, only apply to HTTP/2.
+func WithStreamTransport(ps ...protocol.ID) ServerOption {
+	if len(ps) == 0 {
+		ps = []protocol.ID{StreamProtocolID}
+	}
+	return ServerOption{apply: func(s *Server) {
+		s.streamProtocols = append([]protocol.ID(nil), ps...)
+	}}
+}
+
+// WithUnaryInterceptors installs unary interceptors that, unlike the
+// ones of grpc.ChainUnaryInterceptor, also apply to the stream
+// transport.
+func WithUnaryInterceptors(is ...grpc.UnaryServerInterceptor) ServerOption {
+	return ServerOption{apply: func(s *Server) {
+		for _, i := range is {
+			s.grpcOpts = append(s.grpcOpts, grpc.ChainUnaryInterceptor(i))
+			s.unaryInterceptors = append(s.unaryInterceptors, i)
+		}
+	}}
+}
+
+// WithStreamInterceptors installs stream interceptors that, unlike the
+// ones of grpc.ChainStreamInterceptor, also apply to the stream
+// transport.
+func WithStreamInterceptors(is ...grpc.StreamServerInterceptor) ServerOption {
+	return ServerOption{apply: func(s *Server) {
+		for _, i := range is {
+			s.grpcOpts = append(s.grpcOpts, grpc.ChainStreamInterceptor(i))
+			s.streamInterceptors = append(s.streamInterceptors, i)
+		}
+	}}
+}
+
+// streamService is a service registered on the Server, as the stream
+// transport dispatches to it.
+type streamService struct {
+	impl    interface{}
+	methods map[string]*grpc.MethodDesc
+	streams map[string]*grpc.StreamDesc
+}
+
+func (s *Server) registerStreamService(sd *grpc.ServiceDesc, impl interface{}) {
+	svc := &streamService{
+		impl:    impl,
+		methods: make(map[string]*grpc.MethodDesc, len(sd.Methods)),
+		streams: make(map[string]*grpc.StreamDesc, len(sd.Streams)),
+	}
+	for i := range sd.Methods {
+		svc.methods[sd.Methods[i].MethodName] = &sd.Methods[i]
+	}
+	for i := range sd.Streams {
+		svc.streams[sd.Streams[i].StreamName] = &sd.Streams[i]
+	}
+
+	if s.services == nil {
+		s.services = make(map[string]*streamService)
+	}
+	s.services[sd.ServiceName] = svc
+}
+
+// serveStreams starts serving the stream transport. The returned
+// function stops accepting new streams; the RPCs in flight are left to
+// Stop and GracefulStop.
+func (s *Server) serveStreams() func() {
+	for _, p := range s.streamProtocols {
+		s.host.SetStreamHandler(p, func(stream network.Stream) {
+			s.handleStream(stream)
+		})
+	}
+	return s.stopStreams
+}
+
+// stopStreams stops the stream transport from accepting new RPCs.
+func (s *Server) stopStreams() {
+	for _, p := range s.streamProtocols {
+		s.host.RemoveStreamHandler(p)
+	}
+	s.streamCalls.stop()
+}
+
+// streamCalls tracks the RPCs in flight on the stream transport, so
+// that GracefulStop can wait for them.
+type streamCalls struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+func newStreamCalls(ctx context.Context) *streamCalls {
+	c := &streamCalls{}
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	return c
+}
+
+// start registers a new RPC, unless the transport is stopped. A
+// registered RPC must call done when it is over.
+func (c *streamCalls) start() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stopped {
+		return false
+	}
+	c.wg.Add(1)
+	return true
+}
+
+func (c *streamCalls) done() {
+	c.wg.Done()
+}
+
+// stop refuses the RPCs that have not started yet.
+func (c *streamCalls) stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stopped = true
+}
+
+// wait blocks until the started RPCs are over.
+func (c *streamCalls) wait() {
+	c.wg.Wait()
+}
+
+func (s *Server) handleStream(stream network.Stream) {
+	if !s.streamCalls.start() {
+		stream.Reset()
+		return
+	}
+	defer s.streamCalls.done()
+	ctx := s.streamCalls.ctx
+
+	if err := s.limits.admit(stream); err != nil {
+		log.Debugw("refused gRPC stream", "peer", stream.Conn().RemotePeer(), "err", err)
+		s.refuseStream(ctx, stream, refusalError(s.host.ID(), err))
+		return
+	}
+	defer s.limits.release(stream.Conn().RemotePeer())
+
+	r := bufio.NewReader(stream)
+	typ, data, err := readFrame(r, s.maxRecvMsgSize)
+	if err == nil && typ != frameHeader {
+		err = fmt.Errorf("unexpected frame type %d", typ)
+	}
+	var md metadata.MD
+	if err == nil {
+		md, err = decodeMetadata(data)
+	}
+	if err != nil {
+		log.Debugw("malformed gRPC stream", "peer", stream.Conn().RemotePeer(), "err", err)
+		stream.Reset()
+		return
+	}
+
+	method := firstValue(md, pathKey)
+	delete(md, pathKey)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if v := firstValue(md, timeoutKey); v != "" {
+		delete(md, timeoutKey)
+		timeout, err := parseGrpcTimeout(v)
+		if err != nil {
+			stream.Reset()
+			return
+		}
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	ss := &rpcServerStream{
+		stream:  stream,
+		r:       r,
+		w:       bufio.NewWriter(stream),
+		method:  method,
+		codec:   encoding.GetCodec(grpcproto.Name),
+		maxRecv: s.maxRecvMsgSize,
+	}
+	ctx = metadata.NewIncomingContext(ctx, md)
+	ctx = grpcpeer.NewContext(ctx, &grpcpeer.Peer{
+		Addr:     peerAddr{stream.Conn().RemotePeer()},
+		AuthInfo: newAuthInfo(stream),
+	})
+	ss.ctx = grpc.NewContextWithServerTransportStream(ctx, rpcTransportStream{ss})
+
+	if err := ss.finish(s.dispatch(ss)); err != nil {
+		stream.Reset()
+		return
+	}
+	stream.Close()
+}
+
+// refusalTimeout bounds the time spent draining the request of a
+// refused stream.
+const refusalTimeout = 10 * time.Second
+
+// refuseStream ends the call on a refused stream with the status of
+// err. The request is drained first, as closing a stream with unread
+// data may reset it before the client reads the trailers.
+func (s *Server) refuseStream(ctx context.Context, stream network.Stream, err error) {
+	ss := &rpcServerStream{
+		ctx:    ctx,
+		stream: stream,
+		w:      bufio.NewWriter(stream),
+	}
+	if ss.finish(err) != nil || stream.CloseWrite() != nil {
+		stream.Reset()
+		return
+	}
+	stream.SetReadDeadline(time.Now().Add(refusalTimeout))
+	io.Copy(io.Discard, io.LimitReader(stream, int64(s.maxRecvMsgSize)))
+	stream.Close()
+}
+
+// dispatch runs the handler of the method called on ss.
+func (s *Server) dispatch(ss *rpcServerStream) error {
+	service, name, ok := splitMethod(ss.method)
+	if !ok {
+		return status.Errorf(codes.Unimplemented, "libp2pgrpc: malformed method name %q", ss.method)
+	}
+	svc, ok := s.services[service]
+	if !ok {
+		return status.Errorf(codes.Unimplemented, "libp2pgrpc: unknown service %s", service)
+	}
+
+	if md, ok := svc.methods[name]; ok {
+		var received bool
+		dec := func(m interface{}) error {
+			if received {
+				return status.Error(codes.Internal, "libp2pgrpc: more than one request message")
+			}
+			received = true
+			if err := ss.RecvMsg(m); err != nil {
+				if err == io.EOF {
+					return status.Error(codes.Internal, "libp2pgrpc: no request message")
+				}
+				return err
+			}
+			return nil
+		}
+
+		// the response goes out with the trailer
+		ss.buffered = true
+		reply, err := md.Handler(svc.impl, ss.ctx, dec, chainUnaryInterceptors(s.unaryInterceptors))
+		if err != nil {
+			return err
+		}
+		return ss.SendMsg(reply)
+	}
+
+	if sd, ok := svc.streams[name]; ok {
+		info := &grpc.StreamServerInfo{
+			FullMethod:     ss.method,
+			IsClientStream: sd.ClientStreams,
+			IsServerStream: sd.ServerStreams,
+		}
+		handler := sd.Handler
+		for i := len(s.streamInterceptors) - 1; i >= 0; i-- {
+			interceptor, next := s.streamInterceptors[i], handler
+			handler = func(srv interface{}, stream grpc.ServerStream) error {
+				return interceptor(srv, stream, info, next)
+			}
+		}
+		return handler(svc.impl, ss)
+	}
+
+	return status.Errorf(codes.Unimplemented, "libp2pgrpc: unknown method %s for service %s", name, service)
+}
+
+func chainUnaryInterceptors(is []grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	if len(is) == 0 {
+		return nil
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		for i := len(is) - 1; i >= 0; i-- {
+			interceptor, next := is[i], handler
+			handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// splitMethod splits "/service/method".
+func splitMethod(fullMethod string) (string, string, bool) {
+	if !strings.HasPrefix(fullMethod, "/") {
+		return "", "", false
+	}
+	i := strings.LastIndex(fullMethod, "/")
+	if i == 0 {
+		return "", "", false
+	}
+	return fullMethod[1:i], fullMethod[i+1:], true
+}
+
+var _ grpc.ServerStream = &rpcServerStream{}
+
+// rpcServerStream is the server side of an RPC on the stream transport.
+type rpcServerStream struct {
+	ctx     context.Context
+	stream  network.Stream
+	r       *bufio.Reader
+	method  string
+	codec   encoding.Codec
+	maxRecv int
+
+	mu         sync.Mutex
+	w          *bufio.Writer
+	buffered   bool
+	header     metadata.MD
+	headerSent bool
+	trailer    metadata.MD
+}
+
+func (ss *rpcServerStream) Context() context.Context {
+	return ss.ctx
+}
+
+func (ss *rpcServerStream) SetHeader(md metadata.MD) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.headerSent {
+		return errors.New("libp2pgrpc: SetHeader called after the header was sent")
+	}
+	ss.header = metadata.Join(ss.header, md)
+	return nil
+}
+
+func (ss *rpcServerStream) SendHeader(md metadata.MD) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if ss.headerSent {
+		return errors.New("libp2pgrpc: SendHeader called twice")
+	}
+	ss.header = metadata.Join(ss.header, md)
+	return ss.sendHeaderLocked()
+}
+
+func (ss *rpcServerStream) SetTrailer(md metadata.MD) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.trailer = metadata.Join(ss.trailer, md)
+}
+
+func (ss *rpcServerStream) SendMsg(m interface{}) error {
+	data, err := ss.codec.Marshal(m)
+	if err != nil {
+		return status.Errorf(codes.Internal, "libp2pgrpc: marshaling response: %s", err)
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if !ss.headerSent {
+		if err := ss.sendHeaderLocked(); err != nil {
+			return err
+		}
+	}
+	return ss.writeLocked(frameMessage, data)
+}
+
+func (ss *rpcServerStream) RecvMsg(m interface{}) error {
+	typ, data, err := readFrame(ss.r, ss.maxRecv)
+	var tooLarge *frameTooLargeError
+	switch {
+	case err == io.EOF:
+		return io.EOF
+	case errors.As(err, &tooLarge):
+		return status.Error(codes.ResourceExhausted, "libp2pgrpc: "+err.Error())
+	case err != nil:
+		return status.Error(codes.Canceled, err.Error())
+	case typ != frameMessage:
+		return status.Errorf(codes.Internal, "libp2pgrpc: unexpected frame type %d", typ)
+	}
+	if err := ss.codec.Unmarshal(data, m); err != nil {
+		return status.Errorf(codes.Internal, "libp2pgrpc: unmarshaling request: %s", err)
+	}
+	return nil
+}
+
+func (ss *rpcServerStream) sendHeaderLocked() error {
+	ss.headerSent = true
+	return ss.writeLocked(frameHeader, encodeMetadata(ss.header))
+}
+
+// finish ends the response with the outcome of the handler.
+func (ss *rpcServerStream) finish(err error) error {
+	st := status.Convert(err)
+	if ctxErr := ss.ctx.Err(); err != nil && ctxErr != nil && st.Code() == codes.Unknown {
+		st = status.FromContextError(ctxErr)
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if !ss.headerSent && len(ss.header) > 0 {
+		if err := ss.sendHeaderLocked(); err != nil {
+			return err
+		}
+	}
+
+	trailer := ss.trailer.Copy()
+	if trailer == nil {
+		trailer = metadata.MD{}
+	}
+	trailer.Set(statusKey, strconv.Itoa(int(st.Code())))
+	if msg := st.Message(); msg != "" {
+		trailer.Set(messageKey, msg)
+	}
+	if len(st.Details()) > 0 {
+		if details, err := proto.Marshal(st.Proto()); err == nil {
+			trailer.Set(statusDetailsKey, string(details))
+		}
+	}
+	ss.buffered = false
+	return ss.writeLocked(frameTrailer, encodeMetadata(trailer))
+}
+
+// writeLocked writes a frame, flushing it unless the stream is
+// buffered.
+func (ss *rpcServerStream) writeLocked(typ byte, payload []byte) error {
+	err := writeFrame(ss.w, typ, payload)
+	if err == nil && !ss.buffered {
+		err = ss.w.Flush()
+	}
+	if err != nil {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return nil
+}
+
+// rpcTransportStream lets grpc.SetHeader and friends reach an
+// rpcServerStream from the context of a unary handler.
+type rpcTransportStream struct {
+	ss *rpcServerStream
+}
+
+func (ts rpcTransportStream) Method() string                  { return ts.ss.method }
+func (ts rpcTransportStream) SetHeader(md metadata.MD) error  { return ts.ss.SetHeader(md) }
+func (ts rpcTransportStream) SendHeader(md metadata.MD) error { return ts.ss.SendHeader(md) }
+
+func (ts rpcTransportStream) SetTrailer(md metadata.MD) error {
+	ts.ss.SetTrailer(md)
+	return nil
+}
+
+// writeFrame writes one frame.
+func writeFrame(w *bufio.Writer, typ byte, payload []byte) error {
+	var hdr [1 + binary.MaxVarintLen64]byte
+	hdr[0] = typ
+	n := binary.PutUvarint(hdr[1:], uint64(len(payload)))
+	if _, err := w.Write(hdr[:1+n]); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readFrame reads one frame of at most limit bytes. It returns io.EOF
+// only if r ends before the frame starts.
+func readFrame(r *bufio.Reader, limit int) (byte, []byte, error) {
+	typ, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, nil, unexpectedEOF(err)
+	}
+
+	data, err := readPayload(r, n, limit)
+	if err != nil {
+		return 0, nil, err
+	}
+	return typ, data, nil
+}
+
+// frameTooLargeError reports a frame longer than the receive limit.
+type frameTooLargeError struct {
+	size  uint64
+	limit int
+}
+
+func (e *frameTooLargeError) Error() string {
+	return fmt.Sprintf("frame of %d bytes exceeds the limit of %d", e.size, e.limit)
+}
+
+// readPayload reads the n bytes of a frame payload. The length comes
+// from the peer, so the buffer only grows as the bytes actually arrive.
+func readPayload(r io.Reader, n uint64, limit int) ([]byte, error) {
+	if n > uint64(limit) {
+		return nil, &frameTooLargeError{size: n, limit: limit}
+	}
+
+	var buf bytes.Buffer
+	read, err := buf.ReadFrom(io.LimitReader(r, int64(n)))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(read) < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// encodeMetadata encodes md as a sequence of uvarint length-prefixed
+// keys and values. Binary values are carried as they are.
+func encodeMetadata(md metadata.MD) []byte {
+	var buf []byte
+	for k, vs := range md {
+		for _, v := range vs {
+			buf = binary.AppendUvarint(buf, uint64(len(k)))
+			buf = append(buf, k...)
+			buf = binary.AppendUvarint(buf, uint64(len(v)))
+			buf = append(buf, v...)
+		}
+	}
+	return buf
+}
+
+func decodeMetadata(data []byte) (metadata.MD, error) {
+	md := metadata.MD{}
+	for len(data) > 0 {
+		k, rest, err := readString(data)
+		if err != nil {
+			return nil, err
+		}
+		v, rest, err := readString(rest)
+		if err != nil {
+			return nil, err
+		}
+		md.Append(k, v)
+		data = rest
+	}
+	return md, nil
+}
+
+func readString(data []byte) (string, []byte, error) {
+	n, size := binary.Uvarint(data)
+	if size <= 0 || uint64(len(data)-size) < n {
+		return "", nil, errors.New("malformed metadata")
+	}
+	data = data[size:]
+	return string(data[:n]), data[n:], nil
+}
+
+func firstValue(md metadata.MD, k string) string {
+	if vs := md[k]; len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
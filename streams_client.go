@@ -0,0 +1,248 @@
+package libp2pgrpc
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	grpcproto "google.golang.org/grpc/encoding/proto"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+var _ grpc.ClientConnInterface = &StreamConn{}
+
+// StreamConnOption configures a StreamConn.
+type StreamConnOption func(*StreamConn)
+
+// WithStreamConnProtocol makes the connection use the given protocol
+// ID instead of StreamProtocolID.
+func WithStreamConnProtocol(p protocol.ID) StreamConnOption {
+	return func(c *StreamConn) {
+		c.protocol = p
+	}
+}
+
+// StreamConn issues RPCs to a peer on the stream transport of
+// WithStreamTransport: every RPC opens a libp2p stream of its own, so
+// RPCs don't block one another. It holds no state besides the libp2p
+// connection to the peer, so it needs no closing.
+type StreamConn struct {
+	client   *Client
+	peer     peer.ID
+	protocol protocol.ID
+}
+
+// DialStreams returns a StreamConn to the given peer.
+func (c *Client) DialStreams(peerID peer.ID, opts ...StreamConnOption) *StreamConn {
+	conn := &StreamConn{
+		client:   c,
+		peer:     peerID,
+		protocol: StreamProtocolID,
+	}
+	for _, opt := range opts {
+		opt(conn)
+	}
+	return conn
+}
+
+// Invoke performs a unary RPC.
+func (c *StreamConn) Invoke(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) error {
+	stream, err := c.NewStream(ctx, &grpc.StreamDesc{}, method, opts...)
+	if err != nil {
+		return err
+	}
+	return invoke(stream.(*rpcClientStream), args, reply)
+}
+
+// NewStream begins a streaming RPC on a new libp2p stream. A
+// RelayOption among opts overrides the relay policy of the Client.
+func (c *StreamConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	policy := c.client.relayPolicy
+	for _, opt := range opts {
+		if o, ok := opt.(RelayOption); ok {
+			policy = o.Policy
+		}
+	}
+
+	stream, err := c.client.host.NewStream(relayContext(ctx, policy), c.peer, c.protocol)
+	if err = c.client.checkRelay(c.peer, policy, stream, err); err != nil {
+		var relayErr *RelayedConnError
+		if errors.As(err, &relayErr) {
+			return nil, relayErr
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, status.FromContextError(ctxErr).Err()
+		}
+		return nil, status.Error(codes.Unavailable, c.client.dialFailed(c.peer, err).Error())
+	}
+	if err := stream.Scope().SetService(ResourceService); err != nil {
+		stream.Reset()
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	if md == nil {
+		md = metadata.MD{}
+	}
+	md.Set(pathKey, method)
+	if deadline, ok := ctx.Deadline(); ok {
+		md.Set(timeoutKey, encodeGrpcTimeout(time.Until(deadline)))
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s := &rpcClientStream{
+		clientStream: newClientStream(ctx, cancel, encoding.GetCodec(grpcproto.Name), opts),
+		stream:       stream,
+		r:            bufio.NewReader(stream),
+		w:            bufio.NewWriter(stream),
+		// without client streaming, the single request goes out with
+		// the header on CloseSend
+		buffered: !desc.ClientStreams,
+	}
+	s.transport = s
+	if err := s.write(frameHeader, encodeMetadata(md)); err != nil {
+		stream.Reset()
+		cancel()
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	// resetting the stream unblocks its reads and writes and cancels
+	// the call on the server.
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		if s.err == nil {
+			stream.Reset()
+		}
+		s.mu.Unlock()
+	}()
+	return s, nil
+}
+
+// rpcClientStream is the client side of an RPC on the stream transport.
+type rpcClientStream struct {
+	*clientStream
+	stream network.Stream
+	r      *bufio.Reader
+
+	// the writing side
+	w        *bufio.Writer
+	buffered bool
+}
+
+func (s *rpcClientStream) CloseSend() error {
+	if err := s.w.Flush(); err != nil {
+		return s.transportError(err)
+	}
+	if err := s.stream.CloseWrite(); err != nil {
+		return s.transportError(err)
+	}
+	return nil
+}
+
+func (s *rpcClientStream) SendMsg(m interface{}) error {
+	data, err := s.codec.Marshal(m)
+	if err != nil {
+		return status.Errorf(codes.Internal, "libp2pgrpc: marshaling request: %s", err)
+	}
+	if err := s.write(frameMessage, data); err != nil {
+		// as with gRPC, the actual status is left for RecvMsg
+		return io.EOF
+	}
+	return nil
+}
+
+// write writes a frame, flushing it unless the stream is buffered.
+func (s *rpcClientStream) write(typ byte, payload []byte) error {
+	if err := writeFrame(s.w, typ, payload); err != nil {
+		return err
+	}
+	if s.buffered {
+		return nil
+	}
+	return s.w.Flush()
+}
+
+func (s *rpcClientStream) nextFrame() ([]byte, bool) {
+	typ, data, err := readFrame(s.r, maxFrameSize)
+	if err != nil {
+		if err == io.EOF {
+			err = errors.New("stream ended without trailers")
+		}
+		s.end(nil, s.transportError(err))
+		return nil, false
+	}
+
+	switch typ {
+	case frameHeader:
+		md, err := decodeMetadata(data)
+		if err != nil || s.headerDone {
+			s.end(nil, status.Error(codes.Internal, "libp2pgrpc: malformed response header"))
+			break
+		}
+		s.setHeader(md)
+	case frameMessage:
+		s.setHeader(metadata.MD{})
+		return data, true
+	case frameTrailer:
+		md, err := decodeMetadata(data)
+		if err != nil {
+			s.end(nil, status.Error(codes.Internal, "libp2pgrpc: malformed response trailer"))
+			break
+		}
+		s.setHeader(nil)
+		s.end(parseTrailer(md))
+	default:
+		s.end(nil, status.Errorf(codes.Internal, "libp2pgrpc: unexpected frame type %d", typ))
+	}
+	return nil, false
+}
+
+func (s *rpcClientStream) release(err error) {
+	if err == io.EOF {
+		s.stream.Close()
+	} else {
+		s.stream.Reset()
+	}
+}
+
+// parseTrailer splits a trailer frame into the trailer metadata and the
+// status of the call.
+func parseTrailer(md metadata.MD) (metadata.MD, error) {
+	code := codes.Unknown
+	if v := firstValue(md, statusKey); v != "" {
+		n, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			return md, status.Errorf(codes.Internal, "libp2pgrpc: malformed status %q", v)
+		}
+		code = codes.Code(n)
+	}
+	msg := firstValue(md, messageKey)
+
+	var details *spb.Status
+	if v := firstValue(md, statusDetailsKey); v != "" {
+		details = &spb.Status{}
+		if proto.Unmarshal([]byte(v), details) != nil {
+			details = nil
+		}
+	}
+
+	for _, k := range []string{statusKey, messageKey, statusDetailsKey} {
+		delete(md, k)
+	}
+
+	return md, callStatus(code, msg, details)
+}
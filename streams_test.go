@@ -0,0 +1,232 @@
+package libp2pgrpc_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
+	proto "github.com/drgomesp/go-libp2p-grpc/proto/v1"
+)
+
+// streamsTestService adds an echoing FullDuplexCall to grpcWebTestService.
+type streamsTestService struct {
+	grpcWebTestService
+}
+
+func (streamsTestService) FullDuplexCall(stream testpb.TestService_FullDuplexCallServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&testpb.StreamingOutputCallResponse{Payload: req.GetPayload()}); err != nil {
+			return err
+		}
+	}
+}
+
+// serveStreamTransport serves the node info and test services on the
+// stream transport of srvHost, and returns once they can be reached.
+func serveStreamTransport(t testing.TB, srvHost host.Host, opts ...grpc.ServerOption) {
+	opts = append(opts, libp2pgrpc.WithStreamTransport())
+	srv, err := libp2pgrpc.NewGrpcServer(context.Background(), srvHost, opts...)
+	assert.NoError(t, err)
+	t.Cleanup(srv.Stop)
+
+	proto.RegisterNodeServiceServer(srv, &NodeInfoService{host: srvHost})
+	testpb.RegisterTestServiceServer(srv, streamsTestService{})
+	go srv.Serve()
+
+	assert.Eventually(t, func() bool {
+		for _, p := range srvHost.Mux().Protocols() {
+			if p == libp2pgrpc.StreamProtocolID {
+				return true
+			}
+		}
+		return false
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestStreamTransportUnary(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 2)
+	srvHost, cliHost := hosts[0], hosts[1]
+	serveStreamTransport(t, srvHost)
+
+	conn := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID).DialStreams(srvHost.ID())
+
+	res, err := proto.NewNodeServiceClient(conn).Info(ctx, &proto.NodeInfoRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, srvHost.ID().String(), res.GetId())
+
+	var header, trailer metadata.MD
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-token", "secret")
+	echo, err := testpb.NewTestServiceClient(conn).UnaryCall(ctx,
+		&testpb.SimpleRequest{Payload: &testpb.Payload{Body: []byte("hello")}},
+		grpc.Header(&header), grpc.Trailer(&trailer))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(echo.GetPayload().GetBody()))
+	assert.Equal(t, []string{"secret"}, header.Get("x-echo"))
+	assert.Equal(t, []string{"\x00\x01"}, trailer.Get("x-trailer-bin"))
+
+	_, err = testpb.NewTestServiceClient(conn).UnaryCall(ctx, &testpb.SimpleRequest{
+		ResponseStatus: &testpb.EchoStatus{Code: int32(codes.NotFound), Message: "no such thing"},
+	})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, "no such thing", status.Convert(err).Message())
+
+	_, err = testpb.NewTestServiceClient(conn).EmptyCall(ctx, &testpb.Empty{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+	_, err = testpb.NewUnimplementedServiceClient(conn).UnimplementedCall(ctx, &testpb.Empty{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+func TestStreamTransportMaxRecvMsgSize(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 2)
+	srvHost, cliHost := hosts[0], hosts[1]
+	serveStreamTransport(t, srvHost, libp2pgrpc.WithMaxRecvMsgSize(1024))
+
+	client := testpb.NewTestServiceClient(libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID).DialStreams(srvHost.ID()))
+
+	_, err := client.UnaryCall(ctx, &testpb.SimpleRequest{Payload: &testpb.Payload{Body: make([]byte, 512)}})
+	assert.NoError(t, err)
+	_, err = client.UnaryCall(ctx, &testpb.SimpleRequest{Payload: &testpb.Payload{Body: make([]byte, 2048)}})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestStreamTransportUnaryWriteFailure(t *testing.T) {
+	hosts := newHosts(t, 2)
+	srvHost, cliHost := hosts[0], hosts[1]
+	srvHost.SetStreamHandler("/reset/1.0.0", func(s network.Stream) { _ = s.Reset() })
+
+	conn := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID).
+		DialStreams(srvHost.ID(), libp2pgrpc.WithStreamConnProtocol("/reset/1.0.0"))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// the request outgrows the stream window, so its write fails on the reset
+	_, err := testpb.NewTestServiceClient(conn).UnaryCall(ctx,
+		&testpb.SimpleRequest{Payload: &testpb.Payload{Body: make([]byte, 4<<20)}})
+	assert.NotEqual(t, io.EOF, err)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+}
+
+func TestStreamTransportStreaming(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 2)
+	srvHost, cliHost := hosts[0], hosts[1]
+	serveStreamTransport(t, srvHost)
+
+	client := testpb.NewTestServiceClient(libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID).DialStreams(srvHost.ID()))
+
+	out, err := client.StreamingOutputCall(ctx, &testpb.StreamingOutputCallRequest{
+		ResponseParameters: []*testpb.ResponseParameters{{Size: 1}, {Size: 2}, {Size: 3}},
+	})
+	assert.NoError(t, err)
+	for size := 1; size <= 3; size++ {
+		res, err := out.Recv()
+		assert.NoError(t, err)
+		assert.Len(t, res.GetPayload().GetBody(), size)
+	}
+	_, err = out.Recv()
+	assert.Equal(t, io.EOF, err)
+
+	duplex, err := client.FullDuplexCall(ctx)
+	assert.NoError(t, err)
+	for _, body := range []string{"a", "b", "c"} {
+		assert.NoError(t, duplex.Send(&testpb.StreamingOutputCallRequest{Payload: &testpb.Payload{Body: []byte(body)}}))
+		res, err := duplex.Recv()
+		assert.NoError(t, err)
+		assert.Equal(t, body, string(res.GetPayload().GetBody()))
+	}
+	assert.NoError(t, duplex.CloseSend())
+	_, err = duplex.Recv()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestStreamTransportNoHeadOfLineBlocking(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 2)
+	srvHost, cliHost := hosts[0], hosts[1]
+	serveStreamTransport(t, srvHost)
+
+	conn := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID).DialStreams(srvHost.ID())
+
+	// a duplex call left waiting for its next message
+	blocked, err := testpb.NewTestServiceClient(conn).FullDuplexCall(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, blocked.Send(&testpb.StreamingOutputCallRequest{}))
+	_, err = blocked.Recv()
+	assert.NoError(t, err)
+
+	_, err = proto.NewNodeServiceClient(conn).Info(ctx, &proto.NodeInfoRequest{})
+	assert.NoError(t, err)
+	assert.NoError(t, blocked.CloseSend())
+}
+
+func TestStreamTransportDeadline(t *testing.T) {
+	hosts := newHosts(t, 2)
+	srvHost, cliHost := hosts[0], hosts[1]
+
+	srv, err := libp2pgrpc.NewGrpcServer(context.Background(), srvHost, libp2pgrpc.WithStreamTransport(protocol.ID("/blocking/1.0.0")))
+	assert.NoError(t, err)
+	defer srv.Stop()
+	svc := &blockingService{started: make(chan struct{}, 1), release: make(chan struct{})}
+	testpb.RegisterTestServiceServer(srv, svc)
+	go srv.Serve()
+
+	conn := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID).
+		DialStreams(srvHost.ID(), libp2pgrpc.WithStreamConnProtocol("/blocking/1.0.0"))
+	assert.Eventually(t, func() bool {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		_, err := testpb.NewTestServiceClient(conn).UnaryCall(ctx, &testpb.SimpleRequest{})
+		return status.Code(err) == codes.DeadlineExceeded
+	}, 2*time.Second, 10*time.Millisecond)
+	<-svc.started
+}
+
+func TestStreamTransportInterceptors(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 3)
+	srvHost, allowed, denied := hosts[0], hosts[1], hosts[2]
+
+	var methods []string
+	serveStreamTransport(t, srvHost,
+		libp2pgrpc.WithAuthorization(libp2pgrpc.AllowPeers(allowed.ID())),
+		libp2pgrpc.WithUnaryInterceptors(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			methods = append(methods, info.FullMethod)
+			return handler(ctx, req)
+		}),
+	)
+
+	_, err := proto.NewNodeServiceClient(libp2pgrpc.NewClient(allowed, libp2pgrpc.ProtocolID).DialStreams(srvHost.ID())).
+		Info(ctx, &proto.NodeInfoRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/proto.v1.NodeService/Info"}, methods)
+
+	deniedConn := libp2pgrpc.NewClient(denied, libp2pgrpc.ProtocolID).DialStreams(srvHost.ID())
+	_, err = proto.NewNodeServiceClient(deniedConn).Info(ctx, &proto.NodeInfoRequest{})
+	assertDenied(t, err, denied.ID(), "/proto.v1.NodeService/Info")
+
+	out, err := testpb.NewTestServiceClient(deniedConn).StreamingOutputCall(ctx, &testpb.StreamingOutputCallRequest{})
+	assert.NoError(t, err)
+	_, err = out.Recv()
+	assertDenied(t, err, denied.ID(), "/grpc.testing.TestService/StreamingOutputCall")
+}
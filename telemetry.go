@@ -0,0 +1,348 @@
+package libp2pgrpc
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/multiformats/go-multiaddr"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/noop"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/stats"
+	"google.golang.org/grpc/status"
+)
+
+// instrumentationName names the tracer and meter of the telemetry
+// handlers.
+const instrumentationName = "github.com/drgomesp/go-libp2p-grpc"
+
+// The attributes describing the remote libp2p peer of an RPC, set on
+// its spans and metrics.
+const (
+	// PeerIDKey is the peer ID of the remote peer.
+	PeerIDKey = attribute.Key("libp2p.peer_id")
+	// ProtocolKey is the protocol ID of the libp2p stream.
+	ProtocolKey = attribute.Key("libp2p.protocol")
+	// TransportKey is the libp2p transport of the connection, such as
+	// tcp, quic-v1 or webtransport.
+	TransportKey = attribute.Key("libp2p.transport")
+	// RelayedKey tells whether the connection goes through a relay.
+	RelayedKey = attribute.Key("libp2p.relayed")
+)
+
+// the transports TransportKey reports. As they stack, as in
+// /tcp/.../ws or /quic-v1/webtransport, the last one of an address wins.
+var telemetryTransports = map[int]bool{
+	multiaddr.P_TCP:           true,
+	multiaddr.P_QUIC:          true,
+	multiaddr.P_QUIC_V1:       true,
+	multiaddr.P_WEBTRANSPORT:  true,
+	multiaddr.P_WEBRTC_DIRECT: true,
+	multiaddr.P_WS:            true,
+	multiaddr.P_WSS:           true,
+}
+
+// TelemetryOption configures WithServerTelemetry and
+// WithClientTelemetry.
+type TelemetryOption func(*telemetryConfig)
+
+type telemetryConfig struct {
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+	propagator     propagation.TextMapPropagator
+}
+
+// WithTracerProvider makes the telemetry handler create its spans with
+// tp instead of the global tracer provider.
+func WithTracerProvider(tp trace.TracerProvider) TelemetryOption {
+	return func(c *telemetryConfig) {
+		c.tracerProvider = tp
+	}
+}
+
+// WithMeterProvider makes the telemetry handler record its metrics
+// with mp instead of the global meter provider.
+func WithMeterProvider(mp metric.MeterProvider) TelemetryOption {
+	return func(c *telemetryConfig) {
+		c.meterProvider = mp
+	}
+}
+
+// WithPropagator makes the telemetry handler carry the trace context
+// in the RPC metadata with p instead of the global propagator.
+func WithPropagator(p propagation.TextMapPropagator) TelemetryOption {
+	return func(c *telemetryConfig) {
+		c.propagator = p
+	}
+}
+
+// WithServerTelemetry installs an OpenTelemetry stats handler on the
+// Server. Every RPC gets a server span, continuing the trace of the
+// client, and its duration and message sizes are recorded in the
+// rpc.server.duration, rpc.server.request.size and
+// rpc.server.response.size histograms. Spans and metrics carry the
+// method along with the remote peer ID, protocol ID, transport and
+// whether the connection is relayed.
+//
+// The stream transport of WithStreamTransport is not covered.
+func WithServerTelemetry(opts ...TelemetryOption) ServerOption {
+	return ServerOption{apply: func(s *Server) {
+		s.grpcOpts = append(s.grpcOpts, grpc.StatsHandler(newTelemetryHandler(false, opts)))
+	}}
+}
+
+// WithClientTelemetry installs an OpenTelemetry stats handler on the
+// connections dialed by the Client, the client side counterpart of
+// WithServerTelemetry. The trace context is sent along with every RPC,
+// and the metrics are named rpc.client.duration, rpc.client.request.size
+// and rpc.client.response.size.
+func WithClientTelemetry(opts ...TelemetryOption) ClientOption {
+	return func(c *Client) {
+		c.statsHandler = newTelemetryHandler(true, opts)
+	}
+}
+
+// withStatsHandler adds the stats handler of WithClientTelemetry to
+// opts, if any.
+func (c *Client) withStatsHandler(opts []grpc.DialOption) []grpc.DialOption {
+	if c.statsHandler == nil {
+		return opts
+	}
+	return append(opts, grpc.WithStatsHandler(c.statsHandler))
+}
+
+var _ stats.Handler = &telemetryHandler{}
+
+type telemetryHandler struct {
+	client     bool
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+
+	duration     metric.Float64Histogram
+	requestSize  metric.Int64Histogram
+	responseSize metric.Int64Histogram
+}
+
+func newTelemetryHandler(client bool, opts []TelemetryOption) *telemetryHandler {
+	cfg := telemetryConfig{
+		tracerProvider: otel.GetTracerProvider(),
+		meterProvider:  otel.GetMeterProvider(),
+		propagator:     otel.GetTextMapPropagator(),
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	side := "server"
+	if client {
+		side = "client"
+	}
+	h := &telemetryHandler{
+		client:     client,
+		tracer:     cfg.tracerProvider.Tracer(instrumentationName),
+		propagator: cfg.propagator,
+	}
+
+	meter := cfg.meterProvider.Meter(instrumentationName)
+	var err error
+	if h.duration, err = meter.Float64Histogram("rpc."+side+".duration",
+		metric.WithUnit("ms"),
+		metric.WithDescription("The duration of the RPCs.")); err != nil {
+		otel.Handle(err)
+		h.duration, _ = noop.Meter{}.Float64Histogram("")
+	}
+	if h.requestSize, err = meter.Int64Histogram("rpc."+side+".request.size",
+		metric.WithUnit("By"),
+		metric.WithDescription("The size of the request messages.")); err != nil {
+		otel.Handle(err)
+		h.requestSize, _ = noop.Meter{}.Int64Histogram("")
+	}
+	if h.responseSize, err = meter.Int64Histogram("rpc."+side+".response.size",
+		metric.WithUnit("By"),
+		metric.WithDescription("The size of the response messages.")); err != nil {
+		otel.Handle(err)
+		h.responseSize, _ = noop.Meter{}.Int64Histogram("")
+	}
+	return h
+}
+
+type rpcTelemetryKey struct{}
+
+// rpcTelemetry is the telemetry state of an RPC.
+type rpcTelemetry struct {
+	span trace.Span
+
+	mu      sync.Mutex
+	attrs   []attribute.KeyValue
+	hasPeer bool
+}
+
+// setPeer adds the attributes of the remote peer, once it is known.
+func (r *rpcTelemetry) setPeer(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.hasPeer {
+		return
+	}
+	info, ok := PeerFromContext(ctx)
+	if !ok {
+		return
+	}
+	r.hasPeer = true
+
+	attrs := peerAttributes(info)
+	r.attrs = append(r.attrs, attrs...)
+	r.span.SetAttributes(attrs...)
+}
+
+func (r *rpcTelemetry) attributes(extra ...attribute.KeyValue) metric.MeasurementOption {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return metric.WithAttributes(append(append([]attribute.KeyValue(nil), r.attrs...), extra...)...)
+}
+
+// peerAttributes describes the remote peer of info. In-process
+// connections have no address, so no transport either.
+func peerAttributes(info *AuthInfo) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		PeerIDKey.String(info.ID.String()),
+		ProtocolKey.String(string(info.Protocol)),
+	}
+	if info.Addr == nil {
+		return attrs
+	}
+	transport, relayed := addrTransport(info.Addr)
+	if transport != "" {
+		attrs = append(attrs, TransportKey.String(transport))
+	}
+	return append(attrs, RelayedKey.Bool(relayed))
+}
+
+// addrTransport returns the transport of a connection to addr, and
+// whether it goes through a relay. For a relayed address, the
+// transport is the one to the relay. Browser to browser WebRTC
+// addresses go through a relay only to set up the direct connection.
+func addrTransport(addr multiaddr.Multiaddr) (transport string, relayed bool) {
+	multiaddr.ForEach(addr, func(c multiaddr.Component) bool {
+		switch code := c.Protocol().Code; {
+		case code == multiaddr.P_WEBRTC:
+			transport, relayed = c.Protocol().Name, false
+			return false
+		case code == multiaddr.P_CIRCUIT:
+			relayed = true
+		case telemetryTransports[code] && !relayed:
+			transport = c.Protocol().Name
+		}
+		return true
+	})
+	return transport, relayed
+}
+
+func (h *telemetryHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	name := strings.TrimPrefix(info.FullMethodName, "/")
+	attrs := []attribute.KeyValue{semconv.RPCSystemGRPC}
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		attrs = append(attrs, semconv.RPCService(name[:i]), semconv.RPCMethod(name[i+1:]))
+	}
+
+	kind := trace.SpanKindClient
+	if !h.client {
+		kind = trace.SpanKindServer
+		md, _ := metadata.FromIncomingContext(ctx)
+		ctx = h.propagator.Extract(ctx, metadataCarrier(md))
+	}
+	ctx, span := h.tracer.Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attrs...))
+
+	if h.client {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		md = md.Copy()
+		if md == nil {
+			md = metadata.MD{}
+		}
+		h.propagator.Inject(ctx, metadataCarrier(md))
+		ctx = metadata.NewOutgoingContext(ctx, md)
+	}
+
+	rpc := &rpcTelemetry{span: span, attrs: attrs}
+	// the server already knows the peer, the client only once the
+	// headers are exchanged.
+	rpc.setPeer(ctx)
+	return context.WithValue(ctx, rpcTelemetryKey{}, rpc)
+}
+
+func (h *telemetryHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	rpc, ok := ctx.Value(rpcTelemetryKey{}).(*rpcTelemetry)
+	if !ok {
+		return
+	}
+
+	switch s := s.(type) {
+	case *stats.OutHeader, *stats.InHeader:
+		rpc.setPeer(ctx)
+	case *stats.InPayload:
+		h.recordSize(rpc, !h.client, s.Length)
+	case *stats.OutPayload:
+		h.recordSize(rpc, h.client, s.Length)
+	case *stats.End:
+		code := status.Code(s.Error)
+		if s.Error != nil {
+			rpc.span.SetStatus(otelcodes.Error, status.Convert(s.Error).Message())
+		}
+		rpc.span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(code)))
+
+		// see recordSize for the background context
+		elapsed := float64(s.EndTime.Sub(s.BeginTime)) / float64(time.Millisecond)
+		h.duration.Record(context.Background(), elapsed, rpc.attributes(semconv.RPCGRPCStatusCodeKey.Int(int(code))))
+		rpc.span.End(trace.WithTimestamp(s.EndTime))
+	}
+}
+
+// recordSize records the size of a message. Measurements made with a
+// done context are dropped, and the context of an RPC is done as soon
+// as it ends, so they are made with a background context instead.
+func (h *telemetryHandler) recordSize(rpc *rpcTelemetry, request bool, size int) {
+	if request {
+		h.requestSize.Record(context.Background(), int64(size), rpc.attributes())
+	} else {
+		h.responseSize.Record(context.Background(), int64(size), rpc.attributes())
+	}
+}
+
+func (h *telemetryHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (h *telemetryHandler) HandleConn(context.Context, stats.ConnStats) {}
+
+// metadataCarrier carries the trace context in gRPC metadata.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
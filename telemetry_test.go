@@ -0,0 +1,166 @@
+package libp2pgrpc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	"google.golang.org/grpc/status"
+
+	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
+	proto "github.com/drgomesp/go-libp2p-grpc/proto/v1"
+)
+
+// telemetry collects the spans and metrics of one side of the RPCs.
+type telemetry struct {
+	spans  *tracetest.SpanRecorder
+	reader sdkmetric.Reader
+}
+
+func newTelemetry() (*telemetry, []libp2pgrpc.TelemetryOption) {
+	tel := &telemetry{spans: tracetest.NewSpanRecorder(), reader: sdkmetric.NewManualReader()}
+	return tel, []libp2pgrpc.TelemetryOption{
+		libp2pgrpc.WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(tel.spans))),
+		libp2pgrpc.WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(tel.reader))),
+		libp2pgrpc.WithPropagator(propagation.TraceContext{}),
+	}
+}
+
+// histogram returns the data points of the named histogram.
+func (tel *telemetry) histogram(t *testing.T, name string) []attribute.Set {
+	var rm metricdata.ResourceMetrics
+	assert.NoError(t, tel.reader.Collect(context.Background(), &rm))
+
+	var points []attribute.Set
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			switch data := m.Data.(type) {
+			case metricdata.Histogram[float64]:
+				for _, dp := range data.DataPoints {
+					points = append(points, dp.Attributes)
+				}
+			case metricdata.Histogram[int64]:
+				for _, dp := range data.DataPoints {
+					points = append(points, dp.Attributes)
+				}
+			}
+		}
+	}
+	return points
+}
+
+func assertPeerAttributes(t *testing.T, attrs attribute.Set, id peer.ID) {
+	value := func(k attribute.Key) interface{} {
+		v, _ := attrs.Value(k)
+		return v.AsInterface()
+	}
+	assert.Equal(t, id.String(), value(libp2pgrpc.PeerIDKey))
+	assert.Equal(t, string(libp2pgrpc.ProtocolID), value(libp2pgrpc.ProtocolKey))
+	assert.Equal(t, "tcp", value(libp2pgrpc.TransportKey))
+	assert.Equal(t, false, value(libp2pgrpc.RelayedKey))
+	assert.Equal(t, "proto.v1.NodeService", value(semconv.RPCServiceKey))
+	assert.Equal(t, "Info", value(semconv.RPCMethodKey))
+}
+
+func TestTelemetry(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 2)
+	srvHost, cliHost := hosts[0], hosts[1]
+
+	srvTel, srvOpts := newTelemetry()
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost, libp2pgrpc.WithServerTelemetry(srvOpts...))
+	assert.NoError(t, err)
+	defer srv.Stop()
+	serveNodeInfo(t, srv, srvHost)
+
+	cliTel, cliOpts := newTelemetry()
+	conn, err := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID, libp2pgrpc.WithClientTelemetry(cliOpts...)).
+		Dial(ctx, srvHost.ID())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	_, err = proto.NewNodeServiceClient(conn).Info(ctx, &proto.NodeInfoRequest{}, grpc.WaitForReady(true))
+	assert.NoError(t, err)
+
+	assert.Len(t, cliTel.spans.Ended(), 1)
+	assert.Eventually(t, func() bool { return len(srvTel.spans.Ended()) == 1 }, 2*time.Second, 10*time.Millisecond)
+	cliSpan, srvSpan := cliTel.spans.Ended()[0], srvTel.spans.Ended()[0]
+
+	assert.Equal(t, "proto.v1.NodeService/Info", cliSpan.Name())
+	assert.Equal(t, trace.SpanKindClient, cliSpan.SpanKind())
+	assertPeerAttributes(t, attribute.NewSet(cliSpan.Attributes()...), srvHost.ID())
+
+	assert.Equal(t, "proto.v1.NodeService/Info", srvSpan.Name())
+	assert.Equal(t, trace.SpanKindServer, srvSpan.SpanKind())
+	srvAttrs := attribute.NewSet(srvSpan.Attributes()...)
+	assertPeerAttributes(t, srvAttrs, cliHost.ID())
+
+	// the server continues the trace of the client
+	assert.Equal(t, cliSpan.SpanContext().TraceID(), srvSpan.SpanContext().TraceID())
+	assert.Equal(t, cliSpan.SpanContext().SpanID(), srvSpan.Parent().SpanID())
+
+	for _, name := range []string{"rpc.server.duration", "rpc.server.request.size", "rpc.server.response.size"} {
+		points := srvTel.histogram(t, name)
+		if assert.Len(t, points, 1, name) {
+			assertPeerAttributes(t, points[0], cliHost.ID())
+		}
+	}
+	for _, name := range []string{"rpc.client.duration", "rpc.client.request.size", "rpc.client.response.size"} {
+		points := cliTel.histogram(t, name)
+		if assert.Len(t, points, 1, name) {
+			assertPeerAttributes(t, points[0], srvHost.ID())
+		}
+	}
+}
+
+func TestTelemetryError(t *testing.T) {
+	ctx := context.Background()
+	hosts := newHosts(t, 2)
+	srvHost, cliHost := hosts[0], hosts[1]
+
+	srv, err := libp2pgrpc.NewGrpcServer(ctx, srvHost)
+	assert.NoError(t, err)
+	defer srv.Stop()
+	serveNodeInfo(t, srv, srvHost)
+
+	cliTel, cliOpts := newTelemetry()
+	conn, err := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID, libp2pgrpc.WithClientTelemetry(cliOpts...)).
+		Dial(ctx, srvHost.ID())
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	_, err = testpb.NewTestServiceClient(conn).EmptyCall(ctx, &testpb.Empty{}, grpc.WaitForReady(true))
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	spans := cliTel.spans.Ended()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, otelcodes.Error, spans[0].Status().Code)
+		attrs := attribute.NewSet(spans[0].Attributes()...)
+		code, _ := attrs.Value(semconv.RPCGRPCStatusCodeKey)
+		assert.Equal(t, int64(codes.Unimplemented), code.AsInt64())
+	}
+
+	points := cliTel.histogram(t, "rpc.client.duration")
+	if assert.Len(t, points, 1) {
+		attrs := points[0]
+		code, _ := attrs.Value(semconv.RPCGRPCStatusCodeKey)
+		assert.Equal(t, int64(codes.Unimplemented), code.AsInt64())
+	}
+}
@@ -0,0 +1,34 @@
+package libp2pgrpc
+
+import (
+	"testing"
+
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddrTransport(t *testing.T) {
+	const relay = "/p2p/12D3KooWDpJ7As7BWAwRMfu1VU2WCqNjvq387JEYKDBj4kx6nXTN"
+
+	tests := []struct {
+		addr      string
+		transport string
+		relayed   bool
+	}{
+		{"/ip4/127.0.0.1/tcp/4001", "tcp", false},
+		{"/ip6/::1/udp/4001/quic", "quic", false},
+		{"/ip4/127.0.0.1/udp/4001/quic-v1", "quic-v1", false},
+		{"/ip4/127.0.0.1/udp/4001/quic-v1/webtransport", "webtransport", false},
+		{"/ip4/127.0.0.1/tcp/4001/ws", "ws", false},
+		{"/ip4/127.0.0.1/udp/4001/webrtc-direct", "webrtc-direct", false},
+		{"/ip4/127.0.0.1/tcp/4001" + relay + "/p2p-circuit", "tcp", true},
+		{"/ip4/127.0.0.1/udp/4001/quic-v1" + relay + "/p2p-circuit", "quic-v1", true},
+		{"/ip4/127.0.0.1/tcp/4001" + relay + "/p2p-circuit/webrtc", "webrtc", false},
+		{"/ip4/127.0.0.1", "", false},
+	}
+	for _, tt := range tests {
+		transport, relayed := addrTransport(multiaddr.StringCast(tt.addr))
+		assert.Equal(t, tt.transport, transport, tt.addr)
+		assert.Equal(t, tt.relayed, relayed, tt.addr)
+	}
+}
@@ -0,0 +1,127 @@
+package libp2pgrpc
+
+import (
+	"sync"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// upgradable is a resolver whose connections can be moved off relays.
+type upgradable interface {
+	// relayed reports whether the connection of the resolver to id
+	// runs over a relayed stream.
+	relayed(id peer.ID) bool
+	// reconnect makes gRPC open a new stream to id.
+	reconnect(id peer.ID)
+}
+
+// watchUpgrades moves the connections of r off relays: once a direct
+// connection to a peer of r shows up, as established by hole punching
+// (DCUtR), while the gRPC connection of r to it still runs over a
+// relayed stream, r makes gRPC open a new stream, which libp2p puts on
+// the direct connection. New RPCs go to the new stream, and the relayed
+// one is closed once the RPCs already running on it are done.
+//
+// The stream transport of StreamConn needs none of this, since every
+// RPC opens a stream on the best connection at the time.
+func (c *Client) watchUpgrades(r upgradable) {
+	c.watcherMu.Lock()
+	defer c.watcherMu.Unlock()
+
+	w := c.acquireWatcher()
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	w.resolvers[r] = struct{}{}
+	w.mu.Unlock()
+}
+
+func (c *Client) unwatchUpgrades(r upgradable) {
+	c.watcherMu.Lock()
+	defer c.watcherMu.Unlock()
+
+	w := c.watcher
+	if w == nil {
+		return
+	}
+
+	w.mu.Lock()
+	delete(w.resolvers, r)
+	w.mu.Unlock()
+	c.releaseWatcher(w)
+}
+
+// upgrade reconnects the resolvers whose stream to id is relayed, if
+// there is a direct connection to id.
+func (w *connWatcher) upgrade(id peer.ID) {
+	direct := false
+	for _, conn := range w.host.Network().ConnsToPeer(id) {
+		if !isRelayed(conn) && !conn.Stat().Transient {
+			direct = true
+		}
+	}
+	if !direct {
+		return
+	}
+
+	w.mu.Lock()
+	var resolvers []upgradable
+	for r := range w.resolvers {
+		if r.relayed(id) {
+			resolvers = append(resolvers, r)
+		}
+	}
+	w.mu.Unlock()
+
+	for _, r := range resolvers {
+		log.Debugw("moving gRPC connection to a direct connection", "peer", id)
+		r.reconnect(id)
+	}
+}
+
+// dialedStreamsAttrKey attaches the dialedStreams of a resolver to the
+// addresses it resolves, so that the dialer can record its streams.
+type dialedStreamsAttrKey struct{}
+
+// dialedStreams holds the latest stream dialed to each peer for the
+// addresses of one resolver: the transport its connections run on.
+type dialedStreams struct {
+	mu      sync.Mutex
+	streams map[peer.ID]network.Stream
+}
+
+func newDialedStreams() *dialedStreams {
+	return &dialedStreams{streams: make(map[peer.ID]network.Stream)}
+}
+
+func (d *dialedStreams) set(id peer.ID, s network.Stream) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.streams[id] = s
+}
+
+// relayed reports whether the latest stream to id is still open over a
+// relayed connection.
+func (d *dialedStreams) relayed(id peer.ID) bool {
+	d.mu.Lock()
+	s, ok := d.streams[id]
+	d.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	conn := s.Conn()
+	if conn.IsClosed() || !isRelayed(conn) {
+		return false
+	}
+	for _, open := range conn.GetStreams() {
+		if open.ID() == s.ID() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package libp2pgrpc_test
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peerstore"
+	relayv2 "github.com/libp2p/go-libp2p/p2p/protocol/circuitv2/relay"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	testpb "google.golang.org/grpc/interop/grpc_testing"
+	grpcpeer "google.golang.org/grpc/peer"
+
+	libp2pgrpc "github.com/drgomesp/go-libp2p-grpc"
+	proto "github.com/drgomesp/go-libp2p-grpc/proto/v1"
+)
+
+// infoRelayed calls Info on conn and reports whether the call went
+// through a relay.
+func infoRelayed(t *testing.T, conn *grpc.ClientConn) bool {
+	var p grpcpeer.Peer
+	_, err := proto.NewNodeServiceClient(conn).Info(context.Background(), &proto.NodeInfoRequest{}, grpc.Peer(&p))
+	assert.NoError(t, err)
+
+	info, ok := p.AuthInfo.(*libp2pgrpc.AuthInfo)
+	if !assert.True(t, ok) {
+		return false
+	}
+	_, err = info.Addr.ValueForProtocol(multiaddr.P_CIRCUIT)
+	return err == nil
+}
+
+func TestUpgradeToDirectConnection(t *testing.T) {
+	ctx := context.Background()
+	srvHost, cliHost := relayHosts(t, true, relayv2.WithInfiniteLimits())
+	serveStreamTransport(t, srvHost)
+
+	conn := dialNode(t, cliHost, srvHost)
+	assert.True(t, infoRelayed(t, conn))
+
+	// a call running on the relayed stream
+	duplex, err := testpb.NewTestServiceClient(conn).FullDuplexCall(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, duplex.Send(&testpb.StreamingOutputCallRequest{}))
+	_, err = duplex.Recv()
+	assert.NoError(t, err)
+
+	// the direct connection a hole punch would establish
+	cliHost.Peerstore().AddAddrs(srvHost.ID(), srvHost.Addrs(), peerstore.PermanentAddrTTL)
+	_, err = cliHost.Network().DialPeer(network.WithForceDirectDial(ctx, "hole punch"), srvHost.ID())
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return !infoRelayed(t, conn)
+	}, 5*time.Second, 50*time.Millisecond)
+
+	// the running call is not interrupted
+	assert.NoError(t, duplex.Send(&testpb.StreamingOutputCallRequest{}))
+	_, err = duplex.Recv()
+	assert.NoError(t, err)
+	assert.NoError(t, duplex.CloseSend())
+	_, err = duplex.Recv()
+	assert.Equal(t, io.EOF, err)
+
+	// then the relayed stream is closed
+	assert.Eventually(t, func() bool {
+		for _, c := range cliHost.Network().ConnsToPeer(srvHost.ID()) {
+			if _, err := c.RemoteMultiaddr().ValueForProtocol(multiaddr.P_CIRCUIT); err != nil {
+				continue
+			}
+			for _, s := range c.GetStreams() {
+				if s.Protocol() == libp2pgrpc.ProtocolID {
+					return false
+				}
+			}
+		}
+		return true
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestUpgradeDialPeersToDirectConnection(t *testing.T) {
+	ctx := context.Background()
+	srvHost, cliHost := relayHosts(t, true, relayv2.WithInfiniteLimits())
+	serveStreamTransport(t, srvHost)
+
+	client := libp2pgrpc.NewClient(cliHost, libp2pgrpc.ProtocolID)
+	conn, err := client.DialPeers(ctx, libp2pgrpc.StaticPeers(srvHost.ID()))
+	assert.NoError(t, err)
+	defer conn.Close()
+	assert.NoError(t, callNode(conn))
+	assert.True(t, infoRelayed(t, conn))
+
+	cliHost.Peerstore().AddAddrs(srvHost.ID(), srvHost.Addrs(), peerstore.PermanentAddrTTL)
+	_, err = cliHost.Network().DialPeer(network.WithForceDirectDial(ctx, "hole punch"), srvHost.ID())
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return !infoRelayed(t, conn)
+	}, 5*time.Second, 50*time.Millisecond)
+}